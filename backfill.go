@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go"
+	"github.com/mlandauer/solar-battery-monitoring/pkg/pli"
+)
+
+// Filling in the gaps in influxdb from the days that the PL remembers. Each day is written
+// to the "solar_day" measurement with the time that the day started on the PL's clock and
+// tagged with the day's date. The date is what's used to tell if a day is already there.
+
+const dayMeasurement = "solar_day"
+const dateFormat = "2006-01-02"
+
+// backfill - writes the past days that the PL has recorded to influxdb unless they're
+// already there
+func backfillCommand() {
+	p := openPLI()
+	defer p.Close()
+
+	if p.HistoryLayout == nil {
+		log.Println("Nothing to backfill. Set PLI_HISTORY_LAYOUT to say where the PL stores past days. See the README.")
+		return
+	}
+	days, err := p.DatedHistory()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(days) < 2 {
+		log.Println("The history layout doesn't have any past days to backfill")
+		return
+	}
+
+	influx := connectInflux()
+	defer influx.Close()
+
+	oldest := days[len(days)-1].Date
+	existing, err := existingDates(influx, oldest.AddDate(0, 0, -1))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	written := 0
+	for _, d := range daysToWrite(days, existing) {
+		log.Printf("Writing %v", d.Date.Format("2 Jan 2006"))
+		// The voltages are left out until how the past days store them has been checked
+		_, err = influx.Write(
+			context.Background(), os.Getenv("INFLUXDB_BUCKET"), os.Getenv("INFLUXDB_ORG"),
+			influxdb.NewRowMetric(
+				map[string]interface{}{
+					"soc": d.StateOfCharge,
+					"in":  d.In,
+					"out": d.Out,
+				},
+				dayMeasurement,
+				map[string]string{"date": d.Date.Format(dateFormat)},
+				d.Start,
+			),
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+		written++
+	}
+	log.Printf("Wrote %v days", written)
+}
+
+// daysToWrite returns the past days that aren't already in influxdb. Today is left out
+// because it isn't over yet.
+func daysToWrite(days []pli.DatedDay, existing map[string]bool) []pli.DatedDay {
+	var result []pli.DatedDay
+	for _, d := range days {
+		if d.DaysAgo == 0 {
+			continue
+		}
+		if existing[d.Date.Format(dateFormat)] {
+			log.Printf("Already have %v", d.Date.Format("2 Jan 2006"))
+			continue
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// existingDates returns the dates of the days already in influxdb since the given time
+func existingDates(influx *influxdb.Client, since time.Time) (map[string]bool, error) {
+	flux := fmt.Sprintf(
+		`from(bucket: %q) |> range(start: %v) |> filter(fn: (r) => r._measurement == %q and r._field == "soc") |> keep(columns: ["date"])`,
+		os.Getenv("INFLUXDB_BUCKET"), since.UTC().Format(time.RFC3339), dayMeasurement,
+	)
+	result, err := influx.QueryCSV(context.Background(), flux, os.Getenv("INFLUXDB_ORG"))
+	if err != nil {
+		return nil, err
+	}
+	dates := make(map[string]bool)
+	for result.Next() {
+		row := make(map[string]interface{})
+		err = result.Unmarshal(row)
+		if err != nil {
+			return nil, err
+		}
+		date, ok := row["date"].(string)
+		if ok {
+			dates[date] = true
+		}
+	}
+	return dates, result.Err
+}
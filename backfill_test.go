@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/pli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDaysToWrite(t *testing.T) {
+	day := func(daysAgo int) pli.DatedDay {
+		return pli.DatedDay{
+			DayRecord: pli.DayRecord{DaysAgo: daysAgo},
+			Date:      time.Date(2021, 3, 3-daysAgo, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	days := []pli.DatedDay{day(0), day(1), day(2), day(3)}
+
+	assert.Equal(t, []pli.DatedDay{day(1), day(2), day(3)}, daysToWrite(days, map[string]bool{}))
+	// The day before yesterday is already there
+	assert.Equal(t, []pli.DatedDay{day(1), day(3)}, daysToWrite(days, map[string]bool{"2021-03-01": true}))
+	// Today is never written, even if it isn't there
+	assert.Nil(t, daysToWrite(days, map[string]bool{"2021-03-02": true, "2021-03-01": true, "2021-02-28": true}))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/pli"
+)
+
+// Commands for poking at the PLI by hand. Without a command we just collect measurements.
+
+func runCommand(name string, args []string) {
+	switch name {
+	case "dump":
+		dumpCommand(args)
+	case "diff":
+		diffCommand(args)
+	case "ports":
+		portsCommand()
+	case "history":
+		historyCommand()
+	case "backfill":
+		backfillCommand()
+	case "backup":
+		backupCommand(args)
+	case "compare":
+		compareCommand(args)
+	default:
+		log.Fatalf("Unknown command %q", name)
+	}
+}
+
+// dump [file] - reads all of the PL's RAM and saves it to a file (or prints it out). The
+// file is only written once everything has been read so a failed read doesn't leave
+// half a dump behind.
+func dumpCommand(args []string) {
+	pli := openPLI()
+	defer pli.Close()
+
+	dump, err := pli.DumpRAM()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Read RAM in %v", dump.End.Sub(dump.Start))
+
+	if len(args) == 0 {
+		err = dump.Write(os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = dump.Write(f)
+	if err != nil {
+		f.Close()
+		log.Fatal(err)
+	}
+	err = f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// diff before after - shows which addresses changed between two dumps
+func diffCommand(args []string) {
+	if len(args) != 2 {
+		log.Fatal("Usage: diff <before> <after>")
+	}
+	before := readDumpFile(args[0])
+	after := readDumpFile(args[1])
+	for _, change := range pli.DiffDumps(before, after) {
+		fmt.Println(change)
+	}
+}
+
+func readDumpFile(path string) *pli.Dump {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	dump, err := pli.ReadDump(f)
+	if err != nil {
+		log.Fatalf("%v: %v", path, err)
+	}
+	return dump
+}
+
+// ports - lists the USB serial adapters that the PLI could be plugged into
+func portsCommand() {
+	ports, err := pli.ListUSBSerialPorts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range ports {
+		fmt.Printf("%v %v:%v serial=%q %v %v\n", p.Device, p.VendorID, p.ProductID, p.SerialNumber, p.Manufacturer, p.Product)
+	}
+}
+
+// history - shows the days that the PL has recorded
+func historyCommand() {
+	pli := openPLI()
+	defer pli.Close()
+
+	records, err := pli.History()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, r := range records {
+		fmt.Printf("%2d days ago: SOC %3d%%, %v V - %v V, in %v Ah, out %v Ah\n", r.DaysAgo, r.StateOfCharge, r.MinVoltage, r.MaxVoltage, r.In, r.Out)
+	}
+}
+
+// backup file - saves a copy of the PL's EEPROM, where its settings are, to a file
+func backupCommand(args []string) {
+	if len(args) != 1 {
+		log.Fatal("Usage: backup <file>")
+	}
+	p := openPLI()
+	defer p.Close()
+
+	backup, err := p.BackupSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = writeBackupFile(args[0], backup)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeBackupFile writes the backup to a temporary file next to path and only renames it
+// once it's all there. So, if something goes wrong there's never a half-written backup.
+func writeBackupFile(path string, backup *pli.SettingsBackup) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	err = backup.Write(f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// compare file - shows which EEPROM addresses are different from a backup
+func compareCommand(args []string) {
+	if len(args) != 1 {
+		log.Fatal("Usage: compare <file>")
+	}
+	saved := readBackupFile(args[0])
+	p := openPLI()
+	defer p.Close()
+
+	current, err := p.BackupSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := saved.CheckModel(p); err != nil {
+		log.Println(err)
+	}
+	for _, change := range pli.DiffSettings(current, saved) {
+		fmt.Println(change)
+	}
+}
+
+func readBackupFile(path string) *pli.SettingsBackup {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	backup, err := pli.ReadSettingsBackup(f)
+	if err != nil {
+		log.Fatalf("%v: %v", path, err)
+	}
+	return backup
+}
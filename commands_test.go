@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/pli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBackupFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	backup := pli.SettingsBackup{Model: pli.PL80, SoftwareVersion: 1}
+	backup.EEPROM[10] = 44
+	path := filepath.Join(dir, "pl.settings")
+	assert.Nil(t, writeBackupFile(path, &backup))
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	read, err := pli.ReadSettingsBackup(f)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(44), read.EEPROM[10])
+
+	// The temporary file has been renamed so it's the only file there
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestWriteBackupFileNoDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	err = writeBackupFile(filepath.Join(dir, "missing", "pl.settings"), &pli.SettingsBackup{})
+	assert.NotNil(t, err)
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 0)
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	// "database/sql"
 	// "fmt"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -17,13 +21,14 @@ import (
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/joho/godotenv"
 	"github.com/mlandauer/solar-battery-monitoring/pkg/pli"
+	"github.com/mlandauer/solar-battery-monitoring/pkg/plisim"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func captureAndRecord() {
-	// Only try to read .env file if it exists
+// loadEnv reads environment variables from the .env file if there is one
+func loadEnv() {
 	_, err := os.Stat(".env")
 	if err == nil {
 		err := godotenv.Load()
@@ -31,8 +36,117 @@ func captureAndRecord() {
 			log.Fatal(err)
 		}
 	}
+}
+
+// openPLI sets up communication with the PLI wherever it is and gives up if it can't
+func openPLI() *pli.PLI {
+	p, err := connectPLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return p
+}
+
+// connectPLI sets up communication with the PLI wherever it is
+func connectPLI() (*pli.PLI, error) {
+	// PLI_URL can point at a PLI somewhere else on the network. Otherwise we assume
+	// that it's plugged straight into this machine.
+	url := os.Getenv("PLI_URL")
+	if url == "" {
+		switch runtime.GOOS {
+		case "darwin":
+			url = "serial:///dev/tty.usbserial-AM009SBW?baud=9600"
+		case "linux":
+			// Try each USB serial adapter to find the PLI. PLI_SERIAL_NUMBER picks out
+			// a particular adapter if there's more than one.
+			log.Println("Looking for the PLI...")
+			device, err := pli.Discover(os.Getenv("PLI_SERIAL_NUMBER"), 9600)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Found PLI on %v", device)
+			url = "serial://" + device + "?baud=9600"
+		default:
+			return nil, errors.New("Unsupported operation system")
+		}
+	}
+
+	log.Println("Setting up communication with the PLI...")
+	var port io.ReadWriteCloser
+	var baudRate uint
+	var err error
+	if url == "sim://" {
+		// A pretend PLI for trying things out without any hardware
+		port = plisim.New()
+	} else {
+		port, baudRate, err = pli.OpenPort(url)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// PLI_RECORD saves everything sent to and from the PLI so it can be played back later
+	// with a "replay://" PLI_URL. Each new connection is added to the end of the recording so
+	// that whatever happened before a reconnect isn't lost.
+	if path := os.Getenv("PLI_RECORD"); path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			port.Close()
+			return nil, err
+		}
+		recorder := pli.NewRecorder(port, f)
+		recorder.Comment("Connected to " + url + " at " + time.Now().Format(time.RFC3339))
+		port = recorder
+	}
 
-	// Connect to influxdb
+	// PLI_TRACE logs every byte sent to and from the PLI
+	var opts []pli.Option
+	if os.Getenv("PLI_TRACE") != "" {
+		opts = append(opts, pli.WithTracer(pli.LogTracer{}))
+	}
+	// PLI_HISTORY_LAYOUT is a JSON file that says where the PL stores past days
+	if path := os.Getenv("PLI_HISTORY_LAYOUT"); path != "" {
+		layout, err := readHistoryLayout(path)
+		if err != nil {
+			port.Close()
+			return nil, err
+		}
+		opts = append(opts, pli.WithHistoryLayout(layout))
+	}
+	// PLI_VOLTAGE chooses between reading the battery voltage quickly or precisely
+	switch os.Getenv("PLI_VOLTAGE") {
+	case "", "fast":
+	case "precise":
+		opts = append(opts, pli.WithPreciseVoltage())
+	default:
+		port.Close()
+		return nil, errors.New("PLI_VOLTAGE should be fast or precise")
+	}
+
+	p, err := pli.NewWithPort(port, opts...)
+	if err != nil {
+		port.Close()
+		return nil, err
+	}
+	p.BaudRate = baudRate
+	return p, nil
+}
+
+func readHistoryLayout(path string) (pli.HistoryLayout, error) {
+	var layout pli.HistoryLayout
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return layout, err
+	}
+	err = json.Unmarshal(b, &layout)
+	if err != nil {
+		return layout, err
+	}
+	return layout, layout.Validate()
+}
+
+// connectInflux connects to influxdb
+func connectInflux() *influxdb.Client {
 	influx, err := influxdb.New(
 		os.Getenv("INFLUXDB_URL"),
 		os.Getenv("INFLUXDB_TOKEN"),
@@ -41,6 +155,11 @@ func captureAndRecord() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	return influx
+}
+
+func captureAndRecord() {
+	influx := connectInflux()
 	defer influx.Close()
 
 	// Connect to postgres
@@ -67,31 +186,50 @@ func captureAndRecord() {
 	// }
 	// defer db.Close()
 
-	var device string
-	switch runtime.GOOS {
-	case "darwin":
-		device = "/dev/tty.usbserial-AM009SBW"
-	case "linux":
-		device = "/dev/ttyUSB0"
-	default:
-		log.Fatal("Unsupported operation system")
+	// PLI_EXTERNAL_VOLTAGE says what's wired up to the PL's external voltage input
+	externalInput := os.Getenv("PLI_EXTERNAL_VOLTAGE")
+	if externalInput == "" {
+		externalInput = "external"
 	}
 
-	// TODO: Don't yet know how we easily get the port name for the device
-	log.Println("Setting up communication with the PLI...")
-	pli, err := pli.New(device, 9600)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// The connection to the PLI gets reopened if the USB serial adapter is unplugged or
+	// stops working
+	conn := pli.NewConn(func() (*pli.PLI, error) {
+		pli, err := connectPLI()
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		log.Printf("System program number: %v", pli.Prog)
+		log.Printf("System voltage: %v V", pli.Voltage)
+		log.Printf("PL Model name: %v", pli.Model)
+		log.Printf("PL Software version: %v", pli.SoftwareVersion)
+		if pli.BaudRate != 0 {
+			log.Printf("PLI baud rate: %v", pli.BaudRate)
+		}
+		systemVoltage.Set(float64(pli.Voltage))
+		return pli, nil
+	})
 	// Make sure to close it later.
-	defer pli.Close()
-
-	log.Printf("System program number: %v", pli.Prog)
-	log.Printf("System voltage: %v V", pli.Voltage)
-	log.Printf("PL Model name: %v", pli.Model)
-	log.Printf("PL Software version: %v", pli.SoftwareVersion)
+	defer conn.Close()
 
-	systemVoltage.Set(float64(pli.Voltage))
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Subsystem: "solar",
+		Name:      "pli_connected",
+		Help:      "Whether there is a working connection to the PLI (1) or not (0)",
+	}, func() float64 {
+		if conn.State() == pli.StateConnected {
+			return 1
+		}
+		return 0
+	})
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Subsystem: "solar",
+		Name:      "pli_reconnects_total",
+		Help:      "Number of times the connection to the PLI was lost and opened again",
+	}, func() float64 {
+		return float64(conn.Reconnects())
+	})
 
 	// h, m, s, err := pli.CheckTime()
 	// if err != nil {
@@ -100,88 +238,107 @@ func captureAndRecord() {
 	// log.Printf("Time: %v:%v:%v", h, m, s)
 
 	for {
-		v, err := pli.BatteryVoltage()
-		if err != nil {
-			log.Fatal(err)
+		var r pli.Reading
+		var precise bool
+		err := conn.Do(context.Background(), func(pli *pli.PLI) error {
+			r = pli.Snapshot()
+			precise = pli.PreciseVoltage
+			return r.Err()
+		})
+		// If only some of the values couldn't be read we still record the rest
+		var readingErr *pli.ReadingError
+		if err != nil && !errors.As(err, &readingErr) {
+			log.Println(err)
+			log.Println("Sleeping for ten seconds...")
+			time.Sleep(time.Second * 10)
+			continue
 		}
-		log.Printf("Battery voltage: %v V", v)
-
-		bc, err := pli.BatteryCapacity()
 		if err != nil {
-			log.Fatal(err)
+			log.Printf("Couldn't read everything: %v", err)
 		}
-		log.Printf("Battery capacity: %v Ah", bc)
 
-		soc, err := pli.StateOfCharge()
-		if err != nil {
-			log.Fatal(err)
+		fields := make(map[string]interface{})
+		if r.Has("BatteryVoltage") {
+			log.Printf("Battery voltage: %v V", r.BatteryVoltage)
+			batteryVoltage.Set(float64(r.BatteryVoltage))
+			fields["battery_voltage"] = r.BatteryVoltage
 		}
-		log.Printf("State of charge: %v%%", soc)
-
-		in, err := pli.In()
-		if err != nil {
-			log.Fatal(err)
+		// The scaling of the precise battery voltage is a guess so it's kept separate
+		if precise && r.Has("PreciseBatteryVoltage") {
+			log.Printf("Precise battery voltage: %v V", r.PreciseBatteryVoltage)
+			batteryVoltagePrecise.Set(float64(r.PreciseBatteryVoltage))
+			fields["battery_voltage_precise"] = r.PreciseBatteryVoltage
 		}
-		log.Printf("In: %v Ah", in)
-
-		out, err := pli.Out()
-		if err != nil {
-			log.Fatal(err)
+		if r.Has("BatteryMinVoltage") {
+			log.Printf("Lowest battery voltage today: %v V", r.BatteryMinVoltage)
+			batteryMinVoltage.Set(float64(r.BatteryMinVoltage))
+			fields["battery_min_voltage"] = r.BatteryMinVoltage
 		}
-		log.Printf("Out: %v Ah", out)
-
-		charge, err := pli.Charge()
-		if err != nil {
-			log.Fatal(err)
+		if r.Has("BatteryMaxVoltage") {
+			log.Printf("Highest battery voltage today: %v V", r.BatteryMaxVoltage)
+			batteryMaxVoltage.Set(float64(r.BatteryMaxVoltage))
+			fields["battery_max_voltage"] = r.BatteryMaxVoltage
 		}
-		log.Printf("Charge: %v A", charge)
-
-		load, err := pli.Load()
-		if err != nil {
-			log.Fatal(err)
+		if r.Has("BatteryCapacity") {
+			log.Printf("Battery capacity: %v Ah", r.BatteryCapacity)
+		}
+		if r.Has("StateOfCharge") {
+			log.Printf("State of charge: %v%%", r.StateOfCharge)
+			batteryStateOfCharge.Set(float64(r.StateOfCharge))
+			fields["soc"] = r.StateOfCharge
+		}
+		if r.Has("In") {
+			log.Printf("In: %v Ah", r.In)
+			inGauge.Set(float64(r.In))
+			fields["in"] = r.In
+		}
+		if r.Has("Out") {
+			log.Printf("Out: %v Ah", r.Out)
+			outGauge.Set(float64(r.Out))
+			fields["out"] = r.Out
+		}
+		if r.Has("Charge") {
+			log.Printf("Charge: %v A", r.Charge)
+			chargeGauge.Set(float64(r.Charge))
+			fields["charge"] = r.Charge
+		}
+		if r.Has("Load") {
+			log.Printf("Load: %v A", r.Load)
+			loadGauge.Set(float64(r.Load))
+			fields["load"] = r.Load
 		}
-		log.Printf("Load: %v A", load)
+		if r.Has("RegulatorState") {
+			log.Printf("Regulator State: %v", r.RegulatorState)
+			fields["regulator_state"] = r.RegulatorState
+		}
+		// The external voltage goes in its own measurement so that the name of the input can be a
+		// tag without changing the series of everything else
+		externalFields := make(map[string]interface{})
+		if r.Has("ExternalVoltage") {
+			log.Printf("External voltage (%v): %v V", externalInput, r.ExternalVoltage)
+			externalVoltage.WithLabelValues(externalInput).Set(float64(r.ExternalVoltage))
+			externalFields["external_voltage"] = r.ExternalVoltage
+		}
+		log.Printf("Read in %v", r.Duration)
 
-		state, err := pli.RegulatorState()
-		if err != nil {
-			log.Fatal(err)
+		var metrics []influxdb.Metric
+		if len(fields) > 0 {
+			metrics = append(metrics, influxdb.NewRowMetric(fields, "solar", map[string]string{}, r.Start))
 		}
-		log.Printf("Regulator State: %v", state)
-
-		t := time.Now()
-
-		measurementTime.SetToCurrentTime()
-		batteryVoltage.Set(float64(v))
-		batteryStateOfCharge.Set(float64(soc))
-		inGauge.Set(float64(in))
-		outGauge.Set(float64(out))
-		chargeGauge.Set(float64(charge))
-		loadGauge.Set(float64(load))
-
-		_, err = influx.Write(
-			context.Background(), os.Getenv("INFLUXDB_BUCKET"), os.Getenv("INFLUXDB_ORG"),
-			influxdb.NewRowMetric(
-				map[string]interface{}{
-					"battery_voltage": v,
-					"soc":             soc,
-					"in":              in,
-					"out":             out,
-					"charge":          charge,
-					"load":            load,
-					"regulator_state": state,
-				},
-				"solar",
-				map[string]string{},
-				t,
-			),
-		)
-		if err != nil {
-			log.Fatal(err)
+		if len(externalFields) > 0 {
+			metrics = append(metrics, influxdb.NewRowMetric(externalFields, "solar_external", map[string]string{"external_input": externalInput}, r.Start))
+		}
+		if len(metrics) > 0 {
+			measurementTime.Set(float64(r.Start.UnixNano()) / 1e9)
+			_, err = influx.Write(context.Background(), os.Getenv("INFLUXDB_BUCKET"), os.Getenv("INFLUXDB_ORG"), metrics...)
+			if err != nil {
+				log.Fatal(err)
+			}
 		}
 
 		// _, err = db.Exec(
 		// 	"INSERT INTO measurements (time, battery_voltage, soc, in_value, out_value, charge, load, regulator_state) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
-		// 	t, v, soc, in, out, charge, load, state,
+		// 	r.Start, r.BatteryVoltage, r.StateOfCharge, r.In, r.Out, r.Charge, r.Load, r.RegulatorState,
 		// )
 		// if err != nil {
 		// 	log.Fatal(err)
@@ -203,6 +360,21 @@ var (
 		Name:      "battery_voltage",
 		Help:      "Battery voltage in Volts",
 	})
+	batteryVoltagePrecise = promauto.NewGauge(prometheus.GaugeOpts{
+		Subsystem: "solar",
+		Name:      "battery_voltage_precise",
+		Help:      "Battery voltage in Volts from the PL's higher resolution registers (the scaling is a guess)",
+	})
+	batteryMinVoltage = promauto.NewGauge(prometheus.GaugeOpts{
+		Subsystem: "solar",
+		Name:      "battery_min_voltage",
+		Help:      "Lowest battery voltage since midnight in Volts",
+	})
+	batteryMaxVoltage = promauto.NewGauge(prometheus.GaugeOpts{
+		Subsystem: "solar",
+		Name:      "battery_max_voltage",
+		Help:      "Highest battery voltage since midnight in Volts",
+	})
 	batteryStateOfCharge = promauto.NewGauge(prometheus.GaugeOpts{
 		Subsystem: "solar",
 		Name:      "battery_state_of_charge_percentage",
@@ -228,6 +400,11 @@ var (
 		Name:      "load_amps",
 		Help:      "Current used in Amps",
 	})
+	externalVoltage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "solar",
+		Name:      "external_voltage",
+		Help:      "Voltage on the PL's external voltage input in Volts",
+	}, []string{"input"})
 	systemVoltage = promauto.NewGauge(prometheus.GaugeOpts{
 		Subsystem: "solar",
 		Name:      "system_voltage",
@@ -236,6 +413,13 @@ var (
 )
 
 func main() {
+	loadEnv()
+
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	go func() {
 		captureAndRecord()
 	}()
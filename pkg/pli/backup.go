@@ -0,0 +1,184 @@
+package pli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Saving the PL's settings so they can be checked if the PL loses them. The settings live
+// in EEPROM so a backup is a copy of the whole of it.
+
+// SettingsBackup is a copy of the PL's EEPROM at a moment in time
+type SettingsBackup struct {
+	Saved           time.Time
+	Model           string
+	SoftwareVersion int
+	EEPROM          [256]byte
+}
+
+var ErrBackupFormat = errors.New("Not a valid PL settings backup")
+var ErrBackupModel = errors.New("Backup is from a different model or software version of PL")
+
+// BackupSettings reads every address in EEPROM
+func (pli *PLI) BackupSettings() (*SettingsBackup, error) {
+	return pli.BackupSettingsContext(context.Background())
+}
+
+// BackupSettingsContext is the same as BackupSettings but takes a context
+func (pli *PLI) BackupSettingsContext(ctx context.Context) (*SettingsBackup, error) {
+	b := SettingsBackup{
+		Saved:           time.Now(),
+		Model:           pli.Model,
+		SoftwareVersion: pli.SoftwareVersion,
+	}
+	for i := range b.EEPROM {
+		v, err := pli.ReadEEPROMContext(ctx, byte(i))
+		if err != nil {
+			return nil, err
+		}
+		b.EEPROM[i] = v
+	}
+	return &b, nil
+}
+
+const backupHeader = "pli-settings 1"
+
+// Write saves a backup in a simple text format like a RAM dump. There's a line for each
+// address with its value. Known settings have their name after a "#".
+func (b *SettingsBackup) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, backupHeader)
+	fmt.Fprintf(bw, "saved %v\n", b.Saved.Format(time.RFC3339Nano))
+	fmt.Fprintf(bw, "model %v %v\n", b.Model, b.SoftwareVersion)
+	for i, v := range b.EEPROM {
+		fmt.Fprintf(bw, "%v %v", i, v)
+		if r, ok := LookupEEPROMSetting(byte(i)); ok {
+			fmt.Fprintf(bw, " # %v", r.Setting)
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// ReadSettingsBackup loads a backup saved with Write
+func ReadSettingsBackup(r io.Reader) (*SettingsBackup, error) {
+	var b SettingsBackup
+	scanner := bufio.NewScanner(r)
+	line := 0
+	seen := make(map[int]bool)
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if line == 1 {
+			if text != backupHeader {
+				return nil, ErrBackupFormat
+			}
+			continue
+		}
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		err := b.parseLine(fields, seen)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %v: %v", ErrBackupFormat, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if line == 0 {
+		return nil, ErrBackupFormat
+	}
+	if len(seen) != len(b.EEPROM) {
+		return nil, fmt.Errorf("%w: expected %v addresses but got %v", ErrBackupFormat, len(b.EEPROM), len(seen))
+	}
+	return &b, nil
+}
+
+func (b *SettingsBackup) parseLine(fields []string, seen map[int]bool) error {
+	switch fields[0] {
+	case "saved":
+		if len(fields) != 2 {
+			return errors.New("expected a time")
+		}
+		t, err := time.Parse(time.RFC3339Nano, fields[1])
+		if err != nil {
+			return err
+		}
+		b.Saved = t
+		return nil
+	case "model":
+		if len(fields) != 3 {
+			return errors.New("expected model and software version")
+		}
+		version, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return err
+		}
+		b.Model = fields[1]
+		b.SoftwareVersion = version
+		return nil
+	}
+	if len(fields) != 2 {
+		return errors.New("expected address and value")
+	}
+	address, err := strconv.ParseUint(fields[0], 10, 8)
+	if err != nil {
+		return err
+	}
+	value, err := strconv.ParseUint(fields[1], 10, 8)
+	if err != nil {
+		return err
+	}
+	b.EEPROM[address] = byte(value)
+	seen[int(address)] = true
+	return nil
+}
+
+// SettingChange is an EEPROM address whose value is different in two backups
+type SettingChange struct {
+	Address byte
+	Setting string // Empty if we don't know what the address is
+	Before  byte
+	After   byte
+}
+
+func (c SettingChange) String() string {
+	return fmt.Sprintf("%3d %-4v %3d -> %3d", c.Address, c.Setting, c.Before, c.After)
+}
+
+// DiffSettings returns the EEPROM addresses that are different between two backups in
+// address order
+func DiffSettings(before *SettingsBackup, after *SettingsBackup) []SettingChange {
+	var changes []SettingChange
+	for i := range before.EEPROM {
+		if before.EEPROM[i] == after.EEPROM[i] {
+			continue
+		}
+		c := SettingChange{Address: byte(i), Before: before.EEPROM[i], After: after.EEPROM[i]}
+		if r, ok := LookupEEPROMSetting(byte(i)); ok {
+			c.Setting = r.Setting
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// CheckModel returns an error if the backup was made from a different model of PL or one
+// running different software. Settings might not be stored in the same places.
+func (b *SettingsBackup) CheckModel(pli *PLI) error {
+	if b.Model != pli.Model || b.SoftwareVersion != pli.SoftwareVersion {
+		return fmt.Errorf("%w: backup is %v %v but PL is %v %v", ErrBackupModel, b.Model, b.SoftwareVersion, pli.Model, pli.SoftwareVersion)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package pli
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/plisim"
+	"github.com/stretchr/testify/assert"
+)
+
+// withEEPROMSettings makes the given settings known while running f
+func withEEPROMSettings(settings []Register, f func()) {
+	old := EEPROMSettings
+	EEPROMSettings = settings
+	defer func() { EEPROMSettings = old }()
+	f()
+}
+
+var testSettings = []Register{
+	{Name: "bcap", Address: 10, Width: 1, Setting: "BCAP"},
+	{Name: "volt", Address: 11, Width: 1, Setting: "VOLT"},
+}
+
+func TestWriteAndReadSettingsBackup(t *testing.T) {
+	b := SettingsBackup{
+		Saved:           time.Date(2021, 3, 2, 17, 30, 15, 0, time.UTC),
+		Model:           PL80,
+		SoftwareVersion: 230,
+	}
+	b.EEPROM[10] = 44
+	b.EEPROM[255] = 7
+	var buf bytes.Buffer
+	withEEPROMSettings(testSettings, func() {
+		assert.Nil(t, b.Write(&buf))
+	})
+	assert.True(t, strings.HasPrefix(buf.String(), "pli-settings 1\nsaved 2021-03-02T17:30:15Z\nmodel PL80 230\n0 0\n"))
+	assert.Contains(t, buf.String(), "\n10 44 # BCAP\n11 0 # VOLT\n12 0\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\n255 7\n"))
+
+	read, err := ReadSettingsBackup(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, &b, read)
+}
+
+func TestReadSettingsBackupErrors(t *testing.T) {
+	_, err := ReadSettingsBackup(strings.NewReader(""))
+	assert.Equal(t, ErrBackupFormat, err)
+	_, err = ReadSettingsBackup(strings.NewReader("something else\n"))
+	assert.Equal(t, ErrBackupFormat, err)
+	_, err = ReadSettingsBackup(strings.NewReader("pli-settings 1\n10 300\n"))
+	assert.True(t, errors.Is(err, ErrBackupFormat))
+	// Not all of EEPROM
+	_, err = ReadSettingsBackup(strings.NewReader("pli-settings 1\n10 44\n"))
+	assert.True(t, errors.Is(err, ErrBackupFormat))
+}
+
+func TestDiffSettings(t *testing.T) {
+	var before, after SettingsBackup
+	before.EEPROM[10] = 44
+	after.EEPROM[10] = 60
+	before.EEPROM[11] = 1
+	after.EEPROM[11] = 2
+	after.EEPROM[200] = 5
+	withEEPROMSettings(testSettings, func() {
+		changes := DiffSettings(&before, &after)
+		assert.Equal(t, 3, len(changes))
+		assert.Equal(t, " 10 BCAP  44 ->  60", changes[0].String())
+		assert.Equal(t, " 11 VOLT   1 ->   2", changes[1].String())
+		assert.Equal(t, "200        0 ->   5", changes[2].String())
+	})
+}
+
+func TestBackupSettingsWithSim(t *testing.T) {
+	sim := plisim.New()
+	sim.SetEEPROM(10, 44)
+	sim.SetEEPROM(255, 7)
+	pli, err := NewWithPort(sim)
+	assert.Nil(t, err)
+
+	b, err := pli.BackupSettings()
+	assert.Nil(t, err)
+	assert.Equal(t, PL80, b.Model)
+	assert.Equal(t, 230, b.SoftwareVersion)
+	assert.Equal(t, byte(44), b.EEPROM[10])
+	assert.Equal(t, byte(7), b.EEPROM[255])
+	assert.Nil(t, b.CheckModel(pli))
+
+	b.SoftwareVersion = 229
+	assert.True(t, errors.Is(b.CheckModel(pli), ErrBackupModel))
+}
@@ -0,0 +1,55 @@
+package pli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The baud rates that can be set with the DIP switches on the PLI, fastest first
+var SupportedBaudRates = []uint{9600, 4800, 2400, 1200, 600, 300}
+
+// AutoBaudRate tells New to figure out the baud rate by trying each one the PLI supports
+const AutoBaudRate = 0
+
+var ErrUnsupportedBaudRate = errors.New("Baud rate is not supported by the PLI")
+var ErrBaudRateNotFound = errors.New("PLI did not answer at any of the baud rates it supports")
+
+func checkBaudRate(baudRate uint) error {
+	for _, b := range SupportedBaudRates {
+		if b == baudRate {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrUnsupportedBaudRate, baudRate)
+}
+
+// openSerialDetect opens a local serial port. With AutoBaudRate it tries each of the supported
+// baud rates until the PLI answers the loopback test. It returns the baud rate that was used.
+func openSerialDetect(portName string, baudRate uint) (io.ReadWriteCloser, uint, error) {
+	if baudRate != AutoBaudRate {
+		port, err := openSerial(portName, baudRate)
+		return port, baudRate, err
+	}
+	for _, b := range SupportedBaudRates {
+		port, err := openSerial(portName, b)
+		if err != nil {
+			return nil, 0, err
+		}
+		pli := PLI{Port: port}
+		if pli.loopbackTest() == nil {
+			return port, b, nil
+		}
+		port.Close()
+	}
+	return nil, 0, ErrBaudRateNotFound
+}
+
+// DetectBaudRate finds the baud rate that the PLI plugged into a local serial port is set to
+func DetectBaudRate(portName string) (uint, error) {
+	port, baudRate, err := openSerialDetect(portName, AutoBaudRate)
+	if err != nil {
+		return 0, err
+	}
+	return baudRate, port.Close()
+}
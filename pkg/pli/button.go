@@ -0,0 +1,198 @@
+package pli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Methods for remotely pushing the buttons on the front of the PL
+
+// Button is one of the buttons on the front panel of the PL
+type Button byte
+
+const ButtonMenu Button = 1
+const ButtonSelect Button = 2
+
+// PushButton simulates someone pushing one of the buttons on the PL. A long push is the
+// same as holding the button down for a couple of seconds.
+func (pli *PLI) PushButton(button Button, long bool) error {
+	return pli.PushButtonContext(context.Background(), button, long)
+}
+
+// PushButtonContext is the same as PushButton but takes a context. Unlike other commands a
+// push is never sent again when it fails because pushing a button twice isn't the same as
+// pushing it once. If the push was sent but we can't tell whether the PL acted on it (say
+// because the acknowledgement got lost) the error is an *UnknownPushError.
+func (pli *PLI) PushButtonContext(ctx context.Context, button Button, long bool) error {
+	c := byte(cmdShortPush)
+	if long {
+		c = cmdLongPush
+	}
+	sent := false
+	err := pli.exchange(ctx, func() error {
+		err := pli.transact(c, byte(button), 0, func(port io.Reader) error {
+			sent = true
+			return readAck(port)
+		})
+		return withCommand(err, c, byte(button))
+	})
+	if err != nil && sent && !pushRejected(err) {
+		return &UnknownPushError{Err: err}
+	}
+	return err
+}
+
+// pushRejected is true for errors where the PLI tells us that it didn't pass the push on
+func pushRejected(err error) bool {
+	return errors.Is(err, ErrChecksum) || errors.Is(err, ErrCommandNotRecognised)
+}
+
+var ErrPushUnknown = errors.New("Don't know whether the PL got the button push")
+
+// UnknownPushError is returned when a button push was sent but we don't know whether the
+// PL acted on it. It can be compared to ErrPushUnknown with errors.Is
+type UnknownPushError struct {
+	Err error // What went wrong
+}
+
+func (e *UnknownPushError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrPushUnknown, e.Err)
+}
+
+func (e *UnknownPushError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UnknownPushError) Is(target error) bool {
+	return target == ErrPushUnknown
+}
+
+// The names of the items in the PL's main menu in the order that they are shown when the
+// menu button is pushed. The display starts on the battery voltage. These are from the PL's
+// manual. The names and the order haven't been checked on a real PL.
+const MenuBatteryVoltage = "BATV"
+const MenuSolarVoltage = "SOLV"
+const MenuCharge = "CHRG"
+const MenuLoad = "LOAD"
+const MenuData = "DATA"
+const MenuGenerator = "GEN"
+const MenuSettings = "SET"
+
+var MainMenu = []string{
+	MenuBatteryVoltage,
+	MenuSolarVoltage,
+	MenuCharge,
+	MenuLoad,
+	MenuData,
+	MenuGenerator,
+	MenuSettings,
+}
+
+var ErrUnknownMenuItem = errors.New("Unknown menu item")
+var ErrMenuPositionUnknown = errors.New("Don't know which menu item the PL is showing")
+
+// Menu gets to a particular item by pushing the menu button the right number of times. Which
+// item the PL is showing is kept track of by the PLI so every Menu on a PLI agrees about it.
+// It's safe to use from several goroutines. Only one menu on a PLI is used at a time.
+type Menu struct {
+	pli   *PLI
+	items []string
+}
+
+// Menu starts navigating through the given menu items. To begin with the PLI assumes that the
+// PL is showing the first item. That's a reasonable guess if nobody is standing in front of it
+// because the PL goes back to the battery voltage after a few minutes without any buttons
+// being pushed.
+func (pli *PLI) Menu(items []string) *Menu {
+	return &Menu{pli: pli, items: items}
+}
+
+// Current returns the name of the menu item that the PL should be showing
+func (m *Menu) Current() (string, error) {
+	m.pli.menuMu.Lock()
+	defer m.pli.menuMu.Unlock()
+	if m.pli.menuItem < 0 {
+		return "", ErrMenuPositionUnknown
+	}
+	return m.items[m.pli.menuItem], nil
+}
+
+// Reset tells the menu that the PL is showing the first item again (say after someone has
+// checked the display)
+func (m *Menu) Reset() {
+	m.pli.menuMu.Lock()
+	defer m.pli.menuMu.Unlock()
+	m.pli.menuItem = 0
+}
+
+// GoTo pushes the menu button until the PL is showing the named item. The menu wraps around
+// at the end. If we can't tell whether a button push happened we no longer know where we are
+// and GoTo will refuse to do anything until Reset is called.
+func (m *Menu) GoTo(name string) error {
+	return m.GoToContext(context.Background(), name)
+}
+
+// GoToContext is the same as GoTo but takes a context
+func (m *Menu) GoToContext(ctx context.Context, name string) error {
+	m.pli.menuMu.Lock()
+	defer m.pli.menuMu.Unlock()
+	return m.goTo(ctx, name)
+}
+
+func (m *Menu) goTo(ctx context.Context, name string) error {
+	if m.pli.menuItem < 0 {
+		return ErrMenuPositionUnknown
+	}
+	target := -1
+	for i, item := range m.items {
+		if item == name {
+			target = i
+		}
+	}
+	if target == -1 {
+		return ErrUnknownMenuItem
+	}
+	for m.pli.menuItem != target {
+		err := m.pli.PushButtonContext(ctx, ButtonMenu, false)
+		if errors.Is(err, ErrPushUnknown) {
+			m.pli.menuItem = -1
+		}
+		if err != nil {
+			return err
+		}
+		m.pli.menuItem = (m.pli.menuItem + 1) % len(m.items)
+	}
+	return nil
+}
+
+// Home goes back to the first menu item
+func (m *Menu) Home() error {
+	return m.HomeContext(context.Background())
+}
+
+// HomeContext is the same as Home but takes a context
+func (m *Menu) HomeContext(ctx context.Context) error {
+	return m.GoToContext(ctx, m.items[0])
+}
+
+// Select pushes the select button on the current menu item. What this does depends on the item
+// and the model of PL. If the push fails we no longer know where we are.
+func (m *Menu) Select(long bool) error {
+	return m.SelectContext(context.Background(), long)
+}
+
+// SelectContext is the same as Select but takes a context
+func (m *Menu) SelectContext(ctx context.Context, long bool) error {
+	m.pli.menuMu.Lock()
+	defer m.pli.menuMu.Unlock()
+	if m.pli.menuItem < 0 {
+		return ErrMenuPositionUnknown
+	}
+	err := m.pli.PushButtonContext(ctx, ButtonSelect, long)
+	if err != nil {
+		m.pli.menuItem = -1
+	}
+	return err
+}
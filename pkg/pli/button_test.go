@@ -0,0 +1,115 @@
+package pli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePort records what is written to it and plays back canned responses
+type fakePort struct {
+	written   bytes.Buffer
+	responses bytes.Buffer
+}
+
+func (p *fakePort) Read(b []byte) (int, error)  { return p.responses.Read(b) }
+func (p *fakePort) Write(b []byte) (int, error) { return p.written.Write(b) }
+func (p *fakePort) Close() error                { return nil }
+
+func TestPushButton(t *testing.T) {
+	port := &fakePort{}
+	port.responses.Write([]byte{200})
+	pli := PLI{Port: port}
+	err := pli.PushButton(ButtonSelect, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{92, 2, 0, 163}, port.written.Bytes())
+}
+
+func TestMenuGoTo(t *testing.T) {
+	port := &fakePort{}
+	port.responses.Write([]byte{200, 200})
+	pli := PLI{Port: port}
+	menu := pli.Menu(MainMenu)
+
+	err := menu.GoTo(MenuCharge)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{87, 1, 0, 168, 87, 1, 0, 168}, port.written.Bytes())
+	current, err := menu.Current()
+	assert.Nil(t, err)
+	assert.Equal(t, MenuCharge, current)
+
+	assert.Equal(t, ErrUnknownMenuItem, menu.GoTo("FOO"))
+}
+
+func TestPushButtonNotRetried(t *testing.T) {
+	port := &fakePort{}
+	port.responses.Write([]byte{129, 200})
+	pli := PLI{Port: port}
+	err := pli.PushButton(ButtonMenu, false)
+	assert.True(t, errors.Is(err, ErrPushUnknown))
+	assert.True(t, errors.Is(err, ErrTimeout))
+	// Only pushed once
+	assert.Equal(t, []byte{87, 1, 0, 168}, port.written.Bytes())
+}
+
+func TestPushButtonRejected(t *testing.T) {
+	port := &fakePort{}
+	port.responses.Write([]byte{131})
+	pli := PLI{Port: port}
+	err := pli.PushButton(ButtonMenu, false)
+	assert.True(t, errors.Is(err, ErrCommandNotRecognised))
+	assert.False(t, errors.Is(err, ErrPushUnknown))
+}
+
+func TestMenuRejectedPush(t *testing.T) {
+	port := &fakePort{}
+	port.responses.Write([]byte{131})
+	pli := PLI{Port: port}
+	menu := pli.Menu(MainMenu)
+
+	// The PLI said that the push didn't happen so we still know where we are
+	assert.True(t, errors.Is(menu.GoTo(MenuLoad), ErrCommandNotRecognised))
+	current, err := menu.Current()
+	assert.Nil(t, err)
+	assert.Equal(t, MenuBatteryVoltage, current)
+}
+
+func TestMenuLostPosition(t *testing.T) {
+	port := &fakePort{}
+	port.responses.Write([]byte{200})
+	pli := PLI{Port: port}
+	menu := pli.Menu(MainMenu)
+
+	// The second push gets no acknowledgement
+	assert.True(t, errors.Is(menu.GoTo(MenuLoad), ErrPushUnknown))
+	assert.Equal(t, ErrMenuPositionUnknown, menu.GoTo(MenuLoad))
+	menu.Reset()
+	_, err := menu.Current()
+	assert.Nil(t, err)
+}
+
+func TestMenusShareTheirPosition(t *testing.T) {
+	port := &fakePort{}
+	port.responses.Write([]byte{200, 200})
+	pli := PLI{Port: port}
+
+	assert.Nil(t, pli.Menu(MainMenu).GoTo(MenuCharge))
+	// Another menu on the same PLI knows that the PL has moved on
+	current, err := pli.Menu(MainMenu).Current()
+	assert.Nil(t, err)
+	assert.Equal(t, MenuCharge, current)
+}
+
+func TestMenuSelectFailed(t *testing.T) {
+	port := &fakePort{}
+	port.responses.Write([]byte{131})
+	pli := PLI{Port: port}
+	menu := pli.Menu(MainMenu)
+
+	assert.True(t, errors.Is(menu.SelectContext(context.Background(), false), ErrCommandNotRecognised))
+	_, err := menu.Current()
+	assert.Equal(t, ErrMenuPositionUnknown, err)
+}
@@ -0,0 +1,210 @@
+package pli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Keeping a connection to the PLI going when the USB serial adapter gets unplugged or
+// glitches, which happens every now and then.
+
+// ConnState is whether a Conn currently has a working connection to the PLI
+type ConnState int
+
+const (
+	StateDisconnected ConnState = iota
+	StateConnecting
+	StateConnected
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	default:
+		return "unknown"
+	}
+}
+
+const defaultMinBackoff = time.Second
+const defaultMaxBackoff = time.Minute
+
+// Conn is a connection to a PLI that reconnects when the port breaks. It's safe to use from
+// several goroutines.
+type Conn struct {
+	// MinBackoff and MaxBackoff control how long to wait between attempts to reconnect. The
+	// wait doubles after each failed attempt.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	open func() (*PLI, error)
+
+	connectMu sync.Mutex // Held while connecting so that only one goroutine does it
+
+	mu         sync.Mutex
+	pli        *PLI
+	state      ConnState
+	reconnects int
+	connected  bool  // Whether we've ever been connected
+	lastErr    error // Most recent error opening the connection or using it
+}
+
+// NewConn creates a connection that uses open to open the port and do the handshake (with
+// New or Open for instance). Nothing is opened until the connection is first used.
+func NewConn(open func() (*PLI, error)) *Conn {
+	return &Conn{MinBackoff: defaultMinBackoff, MaxBackoff: defaultMaxBackoff, open: open}
+}
+
+// Do calls f with the PLI, connecting first if necessary. It waits (with backoff) until it
+// gets connected or the context is done. If f returns an error that shows that the port is
+// broken the PLI is closed and will be reopened next time.
+func (c *Conn) Do(ctx context.Context, f func(pli *PLI) error) error {
+	pli, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+	err = f(pli)
+	if IsConnectionError(err) {
+		c.broken(pli, err)
+	}
+	return err
+}
+
+// State returns whether we're connected at the moment
+func (c *Conn) State() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Reconnects returns the number of times that we've connected again after losing the connection
+func (c *Conn) Reconnects() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reconnects
+}
+
+// Err returns the most recent error with the connection
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr
+}
+
+// Close closes the PLI if it's open
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pli == nil {
+		return nil
+	}
+	err := c.pli.Close()
+	c.pli = nil
+	c.state = StateDisconnected
+	return err
+}
+
+func (c *Conn) current() *PLI {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pli
+}
+
+func (c *Conn) get(ctx context.Context) (*PLI, error) {
+	if pli := c.current(); pli != nil {
+		return pli, nil
+	}
+
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	// Another goroutine might have connected while we were waiting
+	if pli := c.current(); pli != nil {
+		return pli, nil
+	}
+
+	backoff := c.MinBackoff
+	for {
+		c.setState(StateConnecting)
+		pli, err := c.open()
+		if err == nil {
+			c.mu.Lock()
+			c.pli = pli
+			c.state = StateConnected
+			if c.connected {
+				c.reconnects++
+			}
+			c.connected = true
+			c.mu.Unlock()
+			return pli, nil
+		}
+		if pli != nil && pli.Port != nil {
+			pli.Close()
+		}
+		c.mu.Lock()
+		c.state = StateDisconnected
+		c.lastErr = err
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+func (c *Conn) setState(state ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
+}
+
+// broken closes the PLI so that it gets reopened next time
+func (c *Conn) broken(pli *PLI, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastErr = err
+	// Someone else might have already reconnected
+	if c.pli != pli {
+		return
+	}
+	pli.Close()
+	c.pli = nil
+	c.state = StateDisconnected
+}
+
+// IsConnectionError is true for errors that show that the port itself is broken (say the USB
+// serial adapter was unplugged or the network connection dropped) rather than the PLI not
+// answering or answering with an error. Reopening the port might fix these. A read that times
+// out without anything arriving isn't one of these. It just means the PLI didn't answer.
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.ErrClosedPipe) || errors.Is(err, os.ErrClosed) || errors.Is(err, ErrConnectionClosed) {
+		return true
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EIO, syscall.ENODEV, syscall.ENXIO, syscall.EBADF, syscall.EPIPE, syscall.ECONNRESET:
+			return true
+		}
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
@@ -0,0 +1,150 @@
+package pli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/plisim"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeUSB hands out a new simulated PLI each time it's opened. Unplugging it breaks the
+// current one and makes opening fail until it's plugged back in.
+type fakeUSB struct {
+	sim     *plisim.Sim
+	opens   int
+	failing int // Number of times opening still has to fail
+}
+
+func (u *fakeUSB) open() (*PLI, error) {
+	u.opens++
+	if u.failing > 0 {
+		u.failing--
+		return nil, errors.New("no such device")
+	}
+	u.sim = plisim.New()
+	return NewWithPort(u.sim)
+}
+
+func (u *fakeUSB) unplug(failures int) {
+	u.sim.Close()
+	u.failing = failures
+}
+
+func newTestConn(usb *fakeUSB) *Conn {
+	conn := NewConn(usb.open)
+	conn.MinBackoff = time.Millisecond
+	conn.MaxBackoff = 4 * time.Millisecond
+	return conn
+}
+
+func batteryVoltage(conn *Conn) (v float32, err error) {
+	err = conn.Do(context.Background(), func(pli *PLI) error {
+		v, err = pli.BatteryVoltage()
+		return err
+	})
+	return
+}
+
+func TestConnConnectsWhenFirstUsed(t *testing.T) {
+	usb := &fakeUSB{}
+	conn := newTestConn(usb)
+	assert.Equal(t, StateDisconnected, conn.State())
+	assert.Equal(t, 0, usb.opens)
+
+	v, err := batteryVoltage(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, float32(25.6), v)
+	assert.Equal(t, StateConnected, conn.State())
+	assert.Equal(t, 0, conn.Reconnects())
+
+	_, err = batteryVoltage(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, usb.opens)
+}
+
+func TestConnReconnectsAfterUnplug(t *testing.T) {
+	usb := &fakeUSB{}
+	conn := newTestConn(usb)
+	_, err := batteryVoltage(conn)
+	assert.Nil(t, err)
+
+	usb.unplug(3)
+	_, err = batteryVoltage(conn)
+	assert.True(t, errors.Is(err, io.ErrClosedPipe))
+	assert.Equal(t, StateDisconnected, conn.State())
+
+	v, err := batteryVoltage(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, float32(25.6), v)
+	assert.Equal(t, StateConnected, conn.State())
+	assert.Equal(t, 1, conn.Reconnects())
+	// One open to start with, three failures and then a successful one
+	assert.Equal(t, 5, usb.opens)
+}
+
+func TestConnKeepsPortAfterProtocolError(t *testing.T) {
+	usb := &fakeUSB{}
+	conn := newTestConn(usb)
+	_, err := batteryVoltage(conn)
+	assert.Nil(t, err)
+
+	usb.sim.FailNext(plisim.CodeNotRecognised)
+	_, err = batteryVoltage(conn)
+	assert.True(t, errors.Is(err, ErrCommandNotRecognised))
+	assert.Equal(t, StateConnected, conn.State())
+	assert.Equal(t, 1, usb.opens)
+}
+
+func TestConnGivesUpWhenContextDone(t *testing.T) {
+	usb := &fakeUSB{failing: 1000}
+	conn := newTestConn(usb)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := conn.Do(ctx, func(pli *PLI) error {
+		t.Error("Shouldn't be called without a connection")
+		return nil
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, StateDisconnected, conn.State())
+	assert.EqualError(t, conn.Err(), "no such device")
+}
+
+func TestIsConnectionError(t *testing.T) {
+	assert.False(t, IsConnectionError(nil))
+	// Timing out just means that the PLI didn't answer
+	assert.False(t, IsConnectionError(io.EOF))
+	assert.False(t, IsConnectionError(ErrTimeout))
+	assert.True(t, IsConnectionError(io.ErrClosedPipe))
+	assert.True(t, IsConnectionError(ErrConnectionClosed))
+	assert.True(t, IsConnectionError(&os.PathError{Op: "read", Path: "/dev/ttyUSB0", Err: syscall.EIO}))
+	assert.False(t, IsConnectionError(&os.PathError{Op: "open", Path: "/dev/ttyUSB0", Err: syscall.EACCES}))
+	assert.True(t, IsConnectionError(&net.OpError{Op: "read", Err: syscall.ECONNRESET}))
+	assert.False(t, IsConnectionError(&ProtocolError{Code: 130}))
+	assert.False(t, IsConnectionError(context.Canceled))
+	assert.True(t, IsConnectionError(&ReadingError{Errors: map[string]error{
+		"BatteryVoltage": &ProtocolError{Code: 130},
+		"StateOfCharge":  io.ErrClosedPipe,
+	}}))
+}
+
+func TestConnKeepsPortWhenPLIDoesNotAnswer(t *testing.T) {
+	usb := &fakeUSB{}
+	conn := newTestConn(usb)
+	_, err := batteryVoltage(conn)
+	assert.Nil(t, err)
+
+	usb.sim.IgnoreNext(1)
+	_, err = batteryVoltage(conn)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, StateConnected, conn.State())
+	_, err = batteryVoltage(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, usb.opens)
+}
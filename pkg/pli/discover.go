@@ -0,0 +1,120 @@
+package pli
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Finding which serial port the PLI is plugged into. USB serial adapters get numbered in
+// the order they're found so /dev/ttyUSB0 today might be /dev/ttyUSB1 tomorrow.
+
+// USBSerialPort is a USB serial adapter plugged into this machine
+type USBSerialPort struct {
+	Device       string // For example /dev/ttyUSB0
+	VendorID     string // USB vendor ID in hex. For example 0403 for FTDI
+	ProductID    string // USB product ID in hex
+	SerialNumber string
+	Manufacturer string
+	Product      string
+}
+
+var ErrNoPLIFound = errors.New("Couldn't find a PLI on any USB serial port")
+
+// ListUSBSerialPorts finds the USB serial adapters plugged into this machine. This looks in
+// sysfs so it only works on Linux.
+func ListUSBSerialPorts() ([]USBSerialPort, error) {
+	return listUSBSerialPorts("/sys", "/dev")
+}
+
+func listUSBSerialPorts(sysfs string, dev string) ([]USBSerialPort, error) {
+	ttys, err := ioutil.ReadDir(filepath.Join(sysfs, "class", "tty"))
+	if err != nil {
+		return nil, err
+	}
+	var ports []USBSerialPort
+	for _, tty := range ttys {
+		device, err := filepath.EvalSymlinks(filepath.Join(sysfs, "class", "tty", tty.Name(), "device"))
+		if err != nil {
+			// Virtual terminals and the like don't have a device
+			continue
+		}
+		usb := usbDeviceDir(sysfs, device)
+		if usb == "" {
+			// Not a USB device
+			continue
+		}
+		ports = append(ports, USBSerialPort{
+			Device:       filepath.Join(dev, tty.Name()),
+			VendorID:     readAttribute(usb, "idVendor"),
+			ProductID:    readAttribute(usb, "idProduct"),
+			SerialNumber: readAttribute(usb, "serial"),
+			Manufacturer: readAttribute(usb, "manufacturer"),
+			Product:      readAttribute(usb, "product"),
+		})
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Device < ports[j].Device })
+	return ports, nil
+}
+
+// usbDeviceDir goes up the tree from a tty's device to find the USB device that it's part of.
+// Returns an empty string if there isn't one.
+func usbDeviceDir(sysfs string, dir string) string {
+	for strings.HasPrefix(dir, sysfs) && dir != sysfs {
+		_, err := os.Stat(filepath.Join(dir, "idVendor"))
+		if err == nil {
+			return dir
+		}
+		dir = filepath.Dir(dir)
+	}
+	return ""
+}
+
+func readAttribute(dir string, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// Discover finds the serial port that the PLI is plugged into by trying the loopback test on
+// each USB serial adapter. If serialNumber isn't empty only the adapter with that serial number
+// is tried. baudRate can be AutoBaudRate.
+func Discover(serialNumber string, baudRate uint) (string, error) {
+	ports, err := ListUSBSerialPorts()
+	if err != nil {
+		return "", err
+	}
+	return discover(ports, serialNumber, baudRate)
+}
+
+func discover(ports []USBSerialPort, serialNumber string, baudRate uint) (string, error) {
+	for _, p := range ports {
+		if serialNumber != "" && p.SerialNumber != serialNumber {
+			continue
+		}
+		if probe(p.Device, baudRate) {
+			return p.Device, nil
+		}
+	}
+	return "", ErrNoPLIFound
+}
+
+// probe is true if there's a PLI answering on the port
+func probe(device string, baudRate uint) bool {
+	port, _, err := openSerialDetect(device, baudRate)
+	if err != nil {
+		return false
+	}
+	defer port.Close()
+	// Automatic detection has already done a loopback test
+	if baudRate == AutoBaudRate {
+		return true
+	}
+	pli := PLI{Port: port}
+	return pli.loopbackTest() == nil
+}
@@ -0,0 +1,24 @@
+package pli
+
+import (
+	"testing"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/plisim"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoverProbes(t *testing.T) {
+	pty, err := plisim.New().ServePTY()
+	if err != nil {
+		t.Skipf("Can't create a pseudo-terminal: %v", err)
+	}
+	defer pty.Close()
+
+	ports := []USBSerialPort{
+		{Device: "/dev/does-not-exist", SerialNumber: "A"},
+		{Device: pty.Path, SerialNumber: "B"},
+	}
+	device, err := discover(ports, "", 9600)
+	assert.Nil(t, err)
+	assert.Equal(t, pty.Path, device)
+}
@@ -0,0 +1,75 @@
+package pli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// makeSysfs creates a cut down copy of sysfs with an FTDI adapter on ttyUSB0, a
+// CDC ACM device on ttyACM0 and a virtual terminal
+func makeSysfs(t *testing.T) string {
+	sysfs, err := ioutil.TempDir("", "sysfs")
+	assert.Nil(t, err)
+
+	mkdir := func(path string) {
+		assert.Nil(t, os.MkdirAll(filepath.Join(sysfs, path), 0755))
+	}
+	write := func(path string, value string) {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(sysfs, path), []byte(value+"\n"), 0644))
+	}
+	symlink := func(target string, path string) {
+		assert.Nil(t, os.Symlink(target, filepath.Join(sysfs, path)))
+	}
+
+	ftdi := "devices/pci0000:00/usb1/1-1"
+	mkdir(ftdi + "/1-1:1.0/ttyUSB0/tty/ttyUSB0")
+	write(ftdi+"/idVendor", "0403")
+	write(ftdi+"/idProduct", "6001")
+	write(ftdi+"/serial", "AM009SBW")
+	write(ftdi+"/manufacturer", "FTDI")
+	write(ftdi+"/product", "FT232R USB UART")
+	symlink("../../../ttyUSB0", ftdi+"/1-1:1.0/ttyUSB0/tty/ttyUSB0/device")
+
+	acm := "devices/pci0000:00/usb1/1-2"
+	mkdir(acm + "/1-2:1.0/tty/ttyACM0")
+	write(acm+"/idVendor", "2341")
+	write(acm+"/idProduct", "0043")
+	symlink("../../../1-2:1.0", acm+"/1-2:1.0/tty/ttyACM0/device")
+
+	mkdir("devices/virtual/tty/tty0")
+
+	mkdir("class/tty")
+	symlink("../../"+ftdi+"/1-1:1.0/ttyUSB0/tty/ttyUSB0", "class/tty/ttyUSB0")
+	symlink("../../"+acm+"/1-2:1.0/tty/ttyACM0", "class/tty/ttyACM0")
+	symlink("../../devices/virtual/tty/tty0", "class/tty/tty0")
+	return sysfs
+}
+
+func TestListUSBSerialPorts(t *testing.T) {
+	sysfs := makeSysfs(t)
+	defer os.RemoveAll(sysfs)
+
+	ports, err := listUSBSerialPorts(sysfs, "/dev")
+	assert.Nil(t, err)
+	assert.Equal(t, []USBSerialPort{
+		{Device: "/dev/ttyACM0", VendorID: "2341", ProductID: "0043"},
+		{
+			Device:       "/dev/ttyUSB0",
+			VendorID:     "0403",
+			ProductID:    "6001",
+			SerialNumber: "AM009SBW",
+			Manufacturer: "FTDI",
+			Product:      "FT232R USB UART",
+		},
+	}, ports)
+}
+
+func TestDiscoverFiltersBySerialNumber(t *testing.T) {
+	ports := []USBSerialPort{{Device: "/dev/does-not-exist", SerialNumber: "A"}}
+	_, err := discover(ports, "B", 9600)
+	assert.Equal(t, ErrNoPLIFound, err)
+}
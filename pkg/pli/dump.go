@@ -0,0 +1,169 @@
+package pli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dumping the whole of the PL's RAM so that we can figure out what the undocumented
+// addresses do by seeing what changes when we do something on the PL.
+
+// Dump is a copy of the whole of the PL's RAM
+type Dump struct {
+	Start     time.Time
+	End       time.Time
+	RAM       [256]byte
+	ReadTimes [256]time.Duration // How long each address took to read (including retries)
+}
+
+// DumpRAM reads every address in RAM. It takes a little while so the values aren't all from
+// exactly the same moment.
+func (pli *PLI) DumpRAM() (*Dump, error) {
+	return pli.DumpRAMContext(context.Background())
+}
+
+// DumpRAMContext is the same as DumpRAM but takes a context
+func (pli *PLI) DumpRAMContext(ctx context.Context) (*Dump, error) {
+	dump := Dump{Start: time.Now()}
+	for i := range dump.RAM {
+		start := time.Now()
+		b, err := pli.ReadRAMContext(ctx, byte(i))
+		if err != nil {
+			return nil, err
+		}
+		dump.RAM[i] = b
+		dump.ReadTimes[i] = time.Since(start)
+	}
+	dump.End = time.Now()
+	return &dump, nil
+}
+
+const dumpHeader = "pli-ram-dump 1"
+
+// Write saves a dump in a simple text format. There's a line for each address with its value
+// and how long it took to read. Known addresses have their name after a "#".
+func (d *Dump) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, dumpHeader)
+	fmt.Fprintf(bw, "start %v\n", d.Start.Format(time.RFC3339Nano))
+	fmt.Fprintf(bw, "end %v\n", d.End.Format(time.RFC3339Nano))
+	for i, b := range d.RAM {
+		fmt.Fprintf(bw, "%v %v %v", i, b, d.ReadTimes[i])
+		name := RegisterName(byte(i))
+		if name != "" {
+			fmt.Fprintf(bw, " # %v", name)
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+var ErrDumpFormat = errors.New("Not a valid PLI RAM dump")
+
+// ReadDump loads a dump that was saved with Write
+func ReadDump(r io.Reader) (*Dump, error) {
+	var d Dump
+	scanner := bufio.NewScanner(r)
+	line := 0
+	seen := make(map[int]bool)
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if line == 1 {
+			if text != dumpHeader {
+				return nil, ErrDumpFormat
+			}
+			continue
+		}
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		err := d.parseLine(fields, seen)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %v: %v", ErrDumpFormat, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(seen) != len(d.RAM) {
+		return nil, fmt.Errorf("%w: expected %v addresses but got %v", ErrDumpFormat, len(d.RAM), len(seen))
+	}
+	return &d, nil
+}
+
+func (d *Dump) parseLine(fields []string, seen map[int]bool) error {
+	switch fields[0] {
+	case "start", "end":
+		if len(fields) != 2 {
+			return errors.New("expected a time")
+		}
+		t, err := time.Parse(time.RFC3339Nano, fields[1])
+		if err != nil {
+			return err
+		}
+		if fields[0] == "start" {
+			d.Start = t
+		} else {
+			d.End = t
+		}
+		return nil
+	}
+	if len(fields) != 3 {
+		return errors.New("expected address, value and read time")
+	}
+	address, err := strconv.ParseUint(fields[0], 10, 8)
+	if err != nil {
+		return err
+	}
+	value, err := strconv.ParseUint(fields[1], 10, 8)
+	if err != nil {
+		return err
+	}
+	readTime, err := time.ParseDuration(fields[2])
+	if err != nil {
+		return err
+	}
+	d.RAM[address] = byte(value)
+	d.ReadTimes[address] = readTime
+	seen[int(address)] = true
+	return nil
+}
+
+// DumpChange is an address whose value is different in two dumps
+type DumpChange struct {
+	Address byte
+	Name    string // Empty if we don't know what the address is
+	Before  byte
+	After   byte
+}
+
+func (c DumpChange) String() string {
+	return fmt.Sprintf("%3d %-6v %3d -> %3d (%+d)", c.Address, c.Name, c.Before, c.After, int(c.After)-int(c.Before))
+}
+
+// DiffDumps returns the addresses that changed between two dumps in address order
+func DiffDumps(before *Dump, after *Dump) []DumpChange {
+	var changes []DumpChange
+	for i := range before.RAM {
+		if before.RAM[i] != after.RAM[i] {
+			changes = append(changes, DumpChange{
+				Address: byte(i),
+				Name:    RegisterName(byte(i)),
+				Before:  before.RAM[i],
+				After:   after.RAM[i],
+			})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,46 @@
+package pli
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpRoundTrip(t *testing.T) {
+	var d Dump
+	d.Start = time.Date(2021, 2, 21, 17, 56, 36, 0, time.UTC)
+	d.End = d.Start.Add(3 * time.Second)
+	for i := range d.RAM {
+		d.RAM[i] = byte(255 - i)
+		d.ReadTimes[i] = time.Duration(i) * time.Millisecond
+	}
+	var buffer bytes.Buffer
+	assert.Nil(t, d.Write(&buffer))
+	assert.Contains(t, buffer.String(), "\n50 205 50ms # batv\n")
+
+	read, err := ReadDump(&buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, d, *read)
+}
+
+func TestReadDumpIncomplete(t *testing.T) {
+	_, err := ReadDump(strings.NewReader("pli-ram-dump 1\n0 1 1ms\n"))
+	assert.True(t, errors.Is(err, ErrDumpFormat))
+	_, err = ReadDump(strings.NewReader("something else\n"))
+	assert.Equal(t, ErrDumpFormat, err)
+}
+
+func TestDiffDumps(t *testing.T) {
+	var before, after Dump
+	after.RAM[50] = 130
+	after.RAM[77] = 1
+	assert.Equal(t, []DumpChange{
+		{Address: 50, Name: "batv", Before: 0, After: 130},
+		{Address: 77, Name: "", Before: 0, After: 1},
+	}, DiffDumps(&before, &after))
+	assert.Equal(t, " 50 batv     0 -> 130 (+130)", DiffDumps(&before, &after)[0].String())
+}
@@ -0,0 +1,258 @@
+package pli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The PL keeps a record of each day (the "day data") which can be looked at on its DATA
+// menu. Today's values are in the documented registers (dsoc, bminl, bmaxl and the Ah totals).
+// Where the past days are stored isn't documented and hasn't been worked out for any model
+// yet. So, reading them needs a HistoryLayout that says where to find them. Without one only
+// today is known. Dumping the RAM
+// (or EEPROM) either side of midnight and diffing the dumps should show where today's values
+// get copied to.
+
+// DayRecord is what the PL recorded about a single day
+type DayRecord struct {
+	DaysAgo       int     // 0 is today (so far), 1 is yesterday and so on
+	StateOfCharge int     // % at the end of the day
+	MinVoltage    float32 // Lowest battery voltage in V
+	MaxVoltage    float32 // Highest battery voltage in V
+	In            int     // Ah charged
+	Out           int     // Ah used
+}
+
+// HistoryLayout says where the past days are stored. Each day is a record of RecordSize
+// bytes starting at Start for yesterday, followed by the day before and so on.
+type HistoryLayout struct {
+	EEPROM     bool // Stored in EEPROM rather than RAM
+	Start      byte // Address of yesterday's record
+	RecordSize int
+	Days       int // Number of past days stored
+
+	// Offset of each value within a record. Voltages are single bytes scaled the same way as
+	// today's (bminl and bmaxl). Ah totals are two bytes with the low byte first.
+	StateOfCharge int
+	MinVoltage    int
+	MaxVoltage    int
+	In            int
+	Out           int
+}
+
+var ErrHistoryLayout = errors.New("Invalid history layout")
+
+// Validate checks that every value is inside a record and that the records fit in memory
+func (l HistoryLayout) Validate() error {
+	if l.RecordSize <= 0 {
+		return fmt.Errorf("%w: RecordSize should be more than 0", ErrHistoryLayout)
+	}
+	if l.Days < 0 {
+		return fmt.Errorf("%w: Days can't be negative", ErrHistoryLayout)
+	}
+	values := []struct {
+		name   string
+		offset int
+		size   int
+	}{
+		{"StateOfCharge", l.StateOfCharge, 1},
+		{"MinVoltage", l.MinVoltage, 1},
+		{"MaxVoltage", l.MaxVoltage, 1},
+		{"In", l.In, 2},
+		{"Out", l.Out, 2},
+	}
+	for _, v := range values {
+		if v.offset < 0 || v.offset+v.size > l.RecordSize {
+			return fmt.Errorf("%w: %v is outside the record", ErrHistoryLayout, v.name)
+		}
+	}
+	if int(l.Start)+l.Days*l.RecordSize > 256 {
+		return fmt.Errorf("%w: goes past the end of memory", ErrHistoryLayout)
+	}
+	return nil
+}
+
+// Today returns what the PL has recorded about today so far
+func (pli *PLI) Today() (DayRecord, error) {
+	return pli.TodayContext(context.Background())
+}
+
+// TodayContext is the same as Today but takes a context
+func (pli *PLI) TodayContext(ctx context.Context) (r DayRecord, err error) {
+	r.StateOfCharge, err = pli.StateOfChargeContext(ctx)
+	if err != nil {
+		return
+	}
+	r.MinVoltage, err = pli.BatteryMinVoltageContext(ctx)
+	if err != nil {
+		return
+	}
+	r.MaxVoltage, err = pli.BatteryMaxVoltageContext(ctx)
+	if err != nil {
+		return
+	}
+	r.In, err = pli.InContext(ctx)
+	if err != nil {
+		return
+	}
+	r.Out, err = pli.OutContext(ctx)
+	return
+}
+
+// History returns the days that the PL has recorded, starting with today. If the PLI doesn't
+// have a HistoryLayout it's only today.
+func (pli *PLI) History() ([]DayRecord, error) {
+	return pli.HistoryContext(context.Background())
+}
+
+// HistoryContext is the same as History but takes a context
+func (pli *PLI) HistoryContext(ctx context.Context) ([]DayRecord, error) {
+	today, err := pli.TodayContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	records := []DayRecord{today}
+	layout := pli.HistoryLayout
+	if layout == nil {
+		return records, nil
+	}
+	err = layout.Validate()
+	if err != nil {
+		return nil, err
+	}
+	for day := 1; day <= layout.Days; day++ {
+		record, err := pli.pastDay(ctx, layout, day)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (pli *PLI) pastDay(ctx context.Context, layout *HistoryLayout, day int) (r DayRecord, err error) {
+	start := int(layout.Start) + (day-1)*layout.RecordSize
+	readByte := func(offset int) (byte, error) {
+		address := byte(start + offset)
+		if layout.EEPROM {
+			return pli.ReadEEPROMContext(ctx, address)
+		}
+		return pli.ReadRAMContext(ctx, address)
+	}
+	readTwoBytes := func(offset int) (int, error) {
+		l, err := readByte(offset)
+		if err != nil {
+			return 0, err
+		}
+		h, err := readByte(offset + 1)
+		if err != nil {
+			return 0, err
+		}
+		return twoBytes(h, l), nil
+	}
+	minVoltage, err := lookupRegister("bminl")
+	if err != nil {
+		return
+	}
+	maxVoltage, err := lookupRegister("bmaxl")
+	if err != nil {
+		return
+	}
+
+	r.DaysAgo = day
+	soc, err := readByte(layout.StateOfCharge)
+	if err != nil {
+		return
+	}
+	r.StateOfCharge = int(soc)
+	min, err := readByte(layout.MinVoltage)
+	if err != nil {
+		return
+	}
+	r.MinVoltage = float32(minVoltage.Scale(int(min), pli.Model, pli.Voltage))
+	max, err := readByte(layout.MaxVoltage)
+	if err != nil {
+		return
+	}
+	r.MaxVoltage = float32(maxVoltage.Scale(int(max), pli.Model, pli.Voltage))
+	r.In, err = readTwoBytes(layout.In)
+	if err != nil {
+		return
+	}
+	r.Out, err = readTwoBytes(layout.Out)
+	return
+}
+
+// DayStart works out when a day recorded by the PL started, given the time now and the time
+// that the PL's clock says it is now. The PL's clock isn't necessarily right so its days
+// don't start at exactly midnight.
+func DayStart(now time.Time, hour int, min int, sec int, daysAgo int) time.Time {
+	sinceMidnight := time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second
+	start := now.Add(-sinceMidnight).Truncate(time.Second)
+	return start.AddDate(0, 0, -daysAgo)
+}
+
+// DayDate works out the date of a day recorded by the PL, given the time now and the time
+// that the PL's clock says it is now. Around midnight the PL's clock can be on a different
+// day to ours so the date is the one with the midnight closest to when the PL's day started.
+// The result is midnight at the start of the date in now's location.
+func DayDate(now time.Time, hour int, min int, sec int, daysAgo int) time.Time {
+	y, m, d := DayStart(now, hour, min, sec, 0).Add(12 * time.Hour).Date()
+	return time.Date(y, m, d-daysAgo, 0, 0, 0, 0, now.Location())
+}
+
+// DatedDay is a day recorded by the PL along with when it was
+type DatedDay struct {
+	DayRecord
+	Start time.Time // When the day started on the PL's clock
+	Date  time.Time // Midnight at the start of the day's date
+}
+
+// How many times to try reading the days before giving up because the PL's day keeps changing
+const maxDatedHistoryReads = 2
+
+var ErrDayChanged = errors.New("The PL's day kept changing while the history was being read")
+
+// DatedHistory is the same as History but also works out when each day was. The PL's clock
+// is read before and after the days. If it went past midnight in between, the days might
+// have moved along so they're read again.
+func (pli *PLI) DatedHistory() ([]DatedDay, error) {
+	return pli.DatedHistoryContext(context.Background())
+}
+
+// DatedHistoryContext is the same as DatedHistory but takes a context
+func (pli *PLI) DatedHistoryContext(ctx context.Context) ([]DatedDay, error) {
+	for i := 0; i < maxDatedHistoryReads; i++ {
+		now := time.Now()
+		hour, min, sec, err := pli.TimeContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		records, err := pli.HistoryContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		hourAfter, minAfter, secAfter, err := pli.TimeContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		before := (hour*60+min)*60 + sec
+		after := (hourAfter*60+minAfter)*60 + secAfter
+		if after < before {
+			continue
+		}
+
+		var days []DatedDay
+		for _, r := range records {
+			days = append(days, DatedDay{
+				DayRecord: r,
+				Start:     DayStart(now, hour, min, sec, r.DaysAgo),
+				Date:      DayDate(now, hour, min, sec, r.DaysAgo),
+			})
+		}
+		return days, nil
+	}
+	return nil, ErrDayChanged
+}
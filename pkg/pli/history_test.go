@@ -0,0 +1,149 @@
+package pli
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/plisim"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryToday(t *testing.T) {
+	sim := plisim.New()
+	sim.SetRAM(188, 30)
+	sim.SetRAM(193, 2)
+	sim.SetRAM(198, 10)
+	pli, err := NewWithPort(sim)
+	assert.Nil(t, err)
+
+	r, err := pli.Today()
+	assert.Nil(t, err)
+	assert.Equal(t, 95, r.StateOfCharge)
+	assert.InDelta(t, 24.2, r.MinVoltage, 0.0001)
+	assert.InDelta(t, 28.0, r.MaxVoltage, 0.0001)
+	assert.Equal(t, 32, r.In)
+	assert.Equal(t, 10, r.Out)
+
+	// Without a layout only today is known
+	records, err := pli.History()
+	assert.Nil(t, err)
+	assert.Equal(t, []DayRecord{r}, records)
+}
+
+func TestHistoryWithLayout(t *testing.T) {
+	sim := plisim.New()
+	// Two days of 7 byte records in EEPROM
+	yesterday := []byte{80, 118, 142, 0x2c, 0x01, 50, 0}
+	dayBefore := []byte{60, 115, 139, 20, 0, 70, 0}
+	for i, b := range append(yesterday, dayBefore...) {
+		sim.SetEEPROM(byte(100+i), b)
+	}
+	pli, err := NewWithPort(sim, WithHistoryLayout(HistoryLayout{
+		EEPROM: true, Start: 100, RecordSize: 7, Days: 2,
+		StateOfCharge: 0, MinVoltage: 1, MaxVoltage: 2, In: 3, Out: 5,
+	}))
+	assert.Nil(t, err)
+
+	records, err := pli.History()
+	assert.Nil(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, 1, records[1].DaysAgo)
+	assert.Equal(t, 80, records[1].StateOfCharge)
+	assert.InDelta(t, 23.6, records[1].MinVoltage, 0.0001)
+	assert.InDelta(t, 28.4, records[1].MaxVoltage, 0.0001)
+	assert.Equal(t, 300, records[1].In)
+	assert.Equal(t, 50, records[1].Out)
+	assert.Equal(t, DayRecord{DaysAgo: 2, StateOfCharge: 60, MinVoltage: records[2].MinVoltage, MaxVoltage: records[2].MaxVoltage, In: 20, Out: 70}, records[2])
+}
+
+func TestHistoryLayoutTooBig(t *testing.T) {
+	pli, err := NewWithPort(plisim.New(), WithHistoryLayout(HistoryLayout{Start: 250, RecordSize: 7, Days: 2, In: 3, Out: 5}))
+	assert.Nil(t, err)
+	_, err = pli.History()
+	assert.True(t, errors.Is(err, ErrHistoryLayout))
+}
+
+func TestHistoryLayoutValidate(t *testing.T) {
+	layout := HistoryLayout{Start: 100, RecordSize: 7, Days: 20, StateOfCharge: 0, MinVoltage: 1, MaxVoltage: 2, In: 3, Out: 5}
+	assert.Nil(t, layout.Validate())
+
+	bad := []HistoryLayout{
+		{Start: 100, RecordSize: 0, Days: 30},
+		{Start: 100, RecordSize: 7, Days: -1},
+		{Start: 100, RecordSize: 7, Days: 30, StateOfCharge: 7},
+		{Start: 100, RecordSize: 7, Days: 30, MinVoltage: -1},
+		// The high byte of Out would be in the next record
+		{Start: 100, RecordSize: 7, Days: 30, Out: 6},
+		{Start: 0, RecordSize: 7, Days: 37},
+	}
+	for _, l := range bad {
+		assert.True(t, errors.Is(l.Validate(), ErrHistoryLayout), "%+v", l)
+	}
+}
+
+func TestDayDate(t *testing.T) {
+	now := time.Date(2021, 3, 2, 23, 59, 0, 0, time.UTC)
+	// The PL's clock is a couple of minutes fast so it's already on the next day
+	assert.Equal(t, time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC), DayDate(now, 0, 1, 0, 0))
+	assert.Equal(t, time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC), DayDate(now, 0, 1, 0, 1))
+
+	now = time.Date(2021, 3, 3, 0, 1, 0, 0, time.UTC)
+	// The PL's clock is a couple of minutes slow so it's still on the day before
+	assert.Equal(t, time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC), DayDate(now, 23, 59, 0, 0))
+	assert.Equal(t, time.Date(2021, 2, 28, 0, 0, 0, 0, time.UTC), DayDate(now, 23, 59, 0, 2))
+}
+
+// midnightPort is a simulated PL whose clock gets to midnight after a given number of commands
+type midnightPort struct {
+	*plisim.Sim
+	midnight  int
+	hourReads int
+}
+
+func (p *midnightPort) Write(b []byte) (int, error) {
+	if p.Commands() == p.midnight {
+		p.SetRAM(46, 0)
+		p.SetRAM(47, 0)
+		p.SetRAM(48, 0)
+	}
+	if b[0] == cmdReadRAM && b[1] == 48 {
+		p.hourReads++
+	}
+	return p.Sim.Write(b)
+}
+
+func TestDatedHistoryAtMidnight(t *testing.T) {
+	sim := plisim.New()
+	sim.SetRAM(46, 29)
+	sim.SetRAM(47, 5)
+	sim.SetRAM(48, 239)
+	port := &midnightPort{Sim: sim, midnight: -1}
+	pli, err := NewWithPort(port, WithHistoryLayout(HistoryLayout{Start: 100, RecordSize: 7, Days: 1, In: 3, Out: 5}))
+	assert.Nil(t, err)
+
+	// It gets to midnight just after the PL's clock is first read
+	port.midnight = sim.Commands() + 3
+	days, err := pli.DatedHistory()
+	assert.Nil(t, err)
+	assert.Len(t, days, 2)
+	// So the clock and the days are read a second time
+	assert.Equal(t, 4, port.hourReads)
+	assert.Equal(t, days[0].Date.AddDate(0, 0, -1), days[1].Date)
+}
+
+func TestDatedHistoryWithoutLayout(t *testing.T) {
+	pli, err := NewWithPort(plisim.New())
+	assert.Nil(t, err)
+	days, err := pli.DatedHistory()
+	assert.Nil(t, err)
+	assert.Len(t, days, 1)
+	assert.Equal(t, 0, days[0].DaysAgo)
+}
+
+func TestDayStart(t *testing.T) {
+	now := time.Date(2021, 3, 2, 17, 30, 15, 500, time.UTC)
+	// The PL's clock is a couple of minutes slow
+	assert.Equal(t, time.Date(2021, 3, 2, 0, 2, 15, 0, time.UTC), DayStart(now, 17, 28, 0, 0))
+	assert.Equal(t, time.Date(2021, 2, 28, 0, 2, 15, 0, time.UTC), DayStart(now, 17, 28, 0, 2))
+}
@@ -1,43 +1,57 @@
 package pli
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"sync"
 	"time"
-
-	"github.com/jacobsa/go-serial/serial"
 )
 
-// PLI is used to talk to a particular PLI
+// PLI is used to talk to a particular PLI. It's safe to use from several goroutines
+// at once. Each command and its response are sent over the port without anything
+// else getting in the way.
 type PLI struct {
 	Port            io.ReadWriteCloser
 	Prog            int // System program
 	Voltage         int // Voltage of battery system
 	Model           string
 	SoftwareVersion int
+	BaudRate        uint           // Zero if it isn't known (say because the PLI is on the network)
+	RetryPolicy     *RetryPolicy   // Uses DefaultRetryPolicy if nil
+	Tracer          Tracer         // Told about everything sent to and from the PLI if not nil
+	PreciseVoltage  bool           // Snapshot reads PreciseBatteryVoltage as well as BatteryVoltage
+	HistoryLayout   *HistoryLayout // Where History finds the past days. Only today is read if nil.
+
+	mu    sync.Mutex // Held while a command is sent and its response is read
+	stale bool       // There might be a late response waiting to be thrown away
+	// Held while a Menu is being used so that pushes from different menus don't get mixed up
+	menuMu sync.Mutex
+	// Which menu item the PL is showing or -1 if we don't know. It starts off on the first item.
+	menuItem int
 }
 
-func New(portName string, baudRate uint) (pli PLI, err error) {
-	// TODO: Check that the baudRate is one of the speeds supported by the PLI
-	// Set up options.
-	// 8 bit, No parity, 1 stop bit is what the PLI expects
-	// 9600 baud is the fastest speed the PLI can work at. That baud rate needs to be setup
-	// with DIP switches on the PLI circuitboard itself. This is like a little glimpse into the past.
-	options := serial.OpenOptions{
-		PortName:   portName,
-		BaudRate:   baudRate,
-		DataBits:   8,
-		StopBits:   1,
-		ParityMode: serial.PARITY_NONE,
-		// Will wait at most 1s for a new byte to arrive
-		InterCharacterTimeout: 1000,
-	}
-
-	// Open the port.
-	port, err := serial.Open(options)
-	pli.Port = port
+// New sets up communication with a PLI plugged into a local serial port. If baudRate is
+// AutoBaudRate then each of the baud rates supported by the PLI is tried in turn.
+func New(portName string, baudRate uint, opts ...Option) (*PLI, error) {
+	port, baudRate, err := openSerialDetect(portName, baudRate)
 	if err != nil {
-		return
+		return nil, err
+	}
+	pli, err := NewWithPort(port, opts...)
+	if pli != nil {
+		pli.BaudRate = baudRate
+	}
+	return pli, err
+}
+
+// NewWithPort sets up communication with a PLI over a port that is already open. This
+// works the same whether it's a local serial port or a network connection.
+func NewWithPort(port io.ReadWriteCloser, opts ...Option) (pli *PLI, err error) {
+	pli = &PLI{Port: port}
+	for _, opt := range opts {
+		opt(pli)
 	}
 
 	err = pli.loopbackTest()
@@ -46,7 +60,7 @@ func New(portName string, baudRate uint) (pli PLI, err error) {
 	}
 
 	// Now get the system voltage (because we need that later to scale some readings)
-	prog, voltage, err := pli.volt()
+	prog, voltage, err := pli.volt(context.Background())
 	if err != nil {
 		return
 	}
@@ -54,7 +68,7 @@ func New(portName string, baudRate uint) (pli PLI, err error) {
 	pli.Voltage = voltage
 
 	// We need the model type for later so we're getting it now
-	model, softwareVersion, err := pli.softwareVersion()
+	model, softwareVersion, err := pli.softwareVersion(context.Background())
 	if err != nil {
 		return
 	}
@@ -64,6 +78,37 @@ func New(portName string, baudRate uint) (pli PLI, err error) {
 	return
 }
 
+// Option changes how a PLI is set up. Options are applied before the handshake.
+type Option func(pli *PLI)
+
+// WithTracer traces everything sent to and from the PLI
+func WithTracer(tracer Tracer) Option {
+	return func(pli *PLI) {
+		pli.Tracer = tracer
+	}
+}
+
+// WithRetryPolicy uses policy instead of DefaultRetryPolicy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(pli *PLI) {
+		pli.RetryPolicy = &policy
+	}
+}
+
+// WithPreciseVoltage makes Snapshot read PreciseBatteryVoltage as well as BatteryVoltage
+func WithPreciseVoltage() Option {
+	return func(pli *PLI) {
+		pli.PreciseVoltage = true
+	}
+}
+
+// WithHistoryLayout tells History where to find the past days
+func WithHistoryLayout(layout HistoryLayout) Option {
+	return func(pli *PLI) {
+		pli.HistoryLayout = &layout
+	}
+}
+
 func (pli *PLI) Close() error {
 	return pli.Port.Close()
 }
@@ -74,26 +119,264 @@ func extractNibbles(value byte) (msn byte, lsn byte) {
 	return
 }
 
-func (pli *PLI) ReadRAM(address byte) (b byte, err error) {
-	const maxRetries = 5
-	const retryWaitTime = 1 * time.Second
+func (pli *PLI) ReadRAM(address byte) (byte, error) {
+	return pli.ReadRAMContext(context.Background(), address)
+}
 
-	for i := 0; i < maxRetries; i++ {
-		err = commandReadRAM(pli.Port, address)
-		if err != nil {
-			return
-		}
-		b, err = readResponse(pli.Port)
-		if err == nil || err != ErrTimeout {
+// ReadRAMContext is the same as ReadRAM but takes a context
+func (pli *PLI) ReadRAMContext(ctx context.Context, address byte) (byte, error) {
+	return pli.read(ctx, cmdReadRAM, address, 0)
+}
+
+// WriteRAM writes a single byte to the PL's RAM at the given address
+func (pli *PLI) WriteRAM(address byte, value byte) error {
+	return pli.WriteRAMContext(context.Background(), address, value)
+}
+
+// WriteRAMContext is the same as WriteRAM but takes a context
+func (pli *PLI) WriteRAMContext(ctx context.Context, address byte, value byte) error {
+	return pli.write(ctx, cmdWriteRAM, address, value)
+}
+
+// WriteRAMVerify writes a single byte to the PL's RAM and then reads it back to make
+// sure that the value actually stuck
+func (pli *PLI) WriteRAMVerify(address byte, value byte) error {
+	return pli.WriteRAMVerifyContext(context.Background(), address, value)
+}
+
+// WriteRAMVerifyContext is the same as WriteRAMVerify but takes a context
+func (pli *PLI) WriteRAMVerifyContext(ctx context.Context, address byte, value byte) error {
+	err := pli.WriteRAMContext(ctx, address, value)
+	if err != nil {
+		return err
+	}
+	b, err := pli.ReadRAMContext(ctx, address)
+	if err != nil {
+		return err
+	}
+	if b != value {
+		return fmt.Errorf("%w: wrote %v to address %v but read back %v", ErrVerify, value, address, b)
+	}
+	return nil
+}
+
+// ReadEEPROM reads a single byte from the PL's EEPROM which is where the settings are
+// stored that survive the PL being reset
+func (pli *PLI) ReadEEPROM(address byte) (byte, error) {
+	return pli.ReadEEPROMContext(context.Background(), address)
+}
+
+// ReadEEPROMContext is the same as ReadEEPROM but takes a context
+func (pli *PLI) ReadEEPROMContext(ctx context.Context, address byte) (byte, error) {
+	return pli.read(ctx, cmdReadEEPROM, address, 0)
+}
+
+// WriteEEPROM writes a single byte to the PL's EEPROM at the given address
+func (pli *PLI) WriteEEPROM(address byte, value byte) error {
+	return pli.WriteEEPROMContext(context.Background(), address, value)
+}
+
+// WriteEEPROMContext is the same as WriteEEPROM but takes a context
+func (pli *PLI) WriteEEPROMContext(ctx context.Context, address byte, value byte) error {
+	return pli.write(ctx, cmdWriteEEPROM, address, value)
+}
+
+// WriteEEPROMVerify writes a single byte to the PL's EEPROM and then reads it back to make
+// sure that the value actually stuck
+func (pli *PLI) WriteEEPROMVerify(address byte, value byte) error {
+	return pli.WriteEEPROMVerifyContext(context.Background(), address, value)
+}
+
+// WriteEEPROMVerifyContext is the same as WriteEEPROMVerify but takes a context
+func (pli *PLI) WriteEEPROMVerifyContext(ctx context.Context, address byte, value byte) error {
+	err := pli.WriteEEPROMContext(ctx, address, value)
+	if err != nil {
+		return err
+	}
+	b, err := pli.ReadEEPROMContext(ctx, address)
+	if err != nil {
+		return err
+	}
+	if b != value {
+		return fmt.Errorf("%w: wrote %v to EEPROM address %v but read back %v", ErrVerify, value, address, b)
+	}
+	return nil
+}
+
+// read sends a command to the PLI that gets a single byte of data in response
+func (pli *PLI) read(ctx context.Context, c byte, address byte, value byte) (b byte, err error) {
+	err = pli.retry(ctx, func(ctx context.Context) error {
+		return pli.exchange(ctx, func() error {
+			err := pli.transact(c, address, value, func(port io.Reader) (err error) {
+				b, err = readResponse(port)
+				return
+			})
+			return withCommand(err, c, address)
+		})
+	})
+	return
+}
+
+// write sends a command to the PLI that gets acknowledged
+func (pli *PLI) write(ctx context.Context, c byte, address byte, value byte) error {
+	return pli.retry(ctx, func(ctx context.Context) error {
+		return pli.exchange(ctx, func() error {
+			return withCommand(pli.transact(c, address, value, readAck), c, address)
+		})
+	})
+}
+
+// deadliner is implemented by ports (like *os.File and network connections) that
+// can interrupt a read that's in progress
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// exchange does a single command and response with the PLI. If the port supports it, a
+// read that is blocked waiting on the PLI is interrupted when the context is done. Otherwise
+// we can be held up for as long as the port's own timeout. If we gave up waiting for the
+// response (or got something we didn't expect) whatever else arrives is thrown away before
+// the next command is sent.
+func (pli *PLI) exchange(ctx context.Context, f func() error) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	pli.mu.Lock()
+	defer pli.mu.Unlock()
+	if pli.stale {
+		pli.drain()
+		pli.stale = false
+	}
+	err = pli.interruptible(ctx, f)
+	var perr *ProtocolError
+	if err != nil && (ctx.Err() != nil || !errors.As(err, &perr)) {
+		pli.stale = true
+	}
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// interruptible calls f and interrupts any read it's doing when the context is done.
+// Must be called with mu held.
+func (pli *PLI) interruptible(ctx context.Context, f func() error) error {
+	d, ok := pli.Port.(deadliner)
+	if ok && d.SetReadDeadline(time.Time{}) == nil {
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				d.SetReadDeadline(time.Now())
+			case <-done:
+			}
+			close(stopped)
+		}()
+		defer func() {
+			close(done)
+			<-stopped
+			d.SetReadDeadline(time.Time{})
+		}()
+	}
+	return f()
+}
+
+// Most bytes that drain will throw away. The PLI never sends more than two at a time so
+// anything more than this means something else is wrong.
+const maxDrain = 64
+
+// drain reads and throws away anything that arrives until the port times out. Otherwise a
+// late response to a command that we gave up on would be taken as the response to the next
+// one. Must be called with mu held.
+func (pli *PLI) drain() {
+	if d, ok := pli.Port.(deadliner); ok && d.SetReadDeadline(time.Now().Add(interCharacterTimeout)) == nil {
+		defer d.SetReadDeadline(time.Time{})
+	}
+	buf := make([]byte, maxDrain)
+	for drained := 0; drained < maxDrain; {
+		n, err := pli.Port.Read(buf)
+		if n == 0 || err != nil {
 			return
 		}
-		time.Sleep(retryWaitTime)
+		drained += n
 	}
-	return
 }
 
+var ErrNoComms = errors.New("PLI Error: No comms or corrupt comms")
 var ErrLoopbackResponse = errors.New("PLI Error: Loopback response code")
 var ErrTimeout = errors.New("PLI Error: Timeout Error")
+var ErrChecksum = errors.New("PLI Error: Checksum error in PLI receive data")
+var ErrCommandNotRecognised = errors.New("PLI Error: Command received by PLI is not recognised")
+var ErrNoReply = errors.New("PLI Error: Processor did not receive a reply to request")
+var ErrReply = errors.New("PLI Error: Error in reply from PL")
+var ErrUnknownCode = errors.New("PLI Error: Unknown error code")
+var ErrVerify = errors.New("PLI Error: Value read back does not match value written")
+
+// ProtocolError is an error code sent back by the PLI. It can be compared to the
+// sentinel errors above with errors.Is
+type ProtocolError struct {
+	Code    byte // Raw error code sent by the PLI
+	Command byte // Command that was being sent when the error happened
+	Address byte // Address that was being read or written
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Command == 0 {
+		return e.Unwrap().Error()
+	}
+	return fmt.Sprintf("%v (%v address %v)", e.Unwrap(), commandName(e.Command), e.Address)
+}
+
+// Unwrap returns the sentinel error for the code
+func (e *ProtocolError) Unwrap() error {
+	switch e.Code {
+	case 5:
+		return ErrNoComms
+	case 128:
+		return ErrLoopbackResponse
+	case 129:
+		return ErrTimeout
+	case 130:
+		return ErrChecksum
+	case 131:
+		return ErrCommandNotRecognised
+	case 133:
+		return ErrNoReply
+	case 134:
+		return ErrReply
+	default:
+		return ErrUnknownCode
+	}
+}
+
+// Transient is true for errors that might well go away if the command is sent again
+func (e *ProtocolError) Transient() bool {
+	switch e.Code {
+	case 5, 129, 130, 133, 134:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsTransient is true if err is an error sent back by the PLI that might go away if the
+// command is sent again
+func IsTransient(err error) bool {
+	var perr *ProtocolError
+	return errors.As(err, &perr) && perr.Transient()
+}
+
+// withCommand records which command and address a protocol error happened with
+func withCommand(err error, command byte, address byte) error {
+	var perr *ProtocolError
+	if errors.As(err, &perr) {
+		perr.Command = command
+		perr.Address = address
+	}
+	return err
+}
 
 // All one byte responses we consider errors (even loopback response)
 func readResponse(port io.Reader) (byte, error) {
@@ -102,6 +385,10 @@ func readResponse(port io.Reader) (byte, error) {
 	if err != nil {
 		return 0, err
 	}
+	if n == 0 {
+		// Nothing arrived before the port's timeout
+		return 0, ErrTimeout
+	}
 	if n == 1 {
 		if buf[0] == 200 {
 			// We expect another byte
@@ -113,26 +400,8 @@ func readResponse(port io.Reader) (byte, error) {
 				return 0, errors.New("Expected another byte")
 			}
 			return buf[0], nil
-		} else {
-			switch buf[0] {
-			case 5:
-				return 0, errors.New("PLI Error: No comms or corrupt comms")
-			case 128:
-				return 0, ErrLoopbackResponse
-			case 129:
-				return 0, ErrTimeout
-			case 130:
-				return 0, errors.New("PLI Error: Checksum error in PLI receive data")
-			case 131:
-				return 0, errors.New("PLI Error: Command received by PLI is not recognised")
-			case 133:
-				return 0, errors.New("PLI Error: Processor did not receive a reply to request")
-			case 134:
-				return 0, errors.New("PLI Error: Error in reply from PL")
-			default:
-				return 0, errors.New("PLI Error: Unknown error code")
-			}
 		}
+		return 0, responseError(buf[0])
 	} else if n == 2 {
 		if buf[0] != 200 {
 			return 0, errors.New("Received one byte more than expected")
@@ -143,26 +412,74 @@ func readResponse(port io.Reader) (byte, error) {
 	}
 }
 
-func (pli *PLI) loopbackTest() error {
-	err := commandLoopbackTest(pli.Port)
+// The PLI acknowledges a write with a single byte
+func readAck(port io.Reader) error {
+	buf := make([]byte, 1)
+	n, err := port.Read(buf)
 	if err != nil {
 		return err
 	}
-	_, err = readResponse(pli.Port)
-	if err == nil {
-		return errors.New("Expected one byte response")
+	if n == 0 {
+		// Nothing arrived before the port's timeout
+		return ErrTimeout
 	}
-	if err != ErrLoopbackResponse {
-		return err
+	if buf[0] != 200 {
+		return responseError(buf[0])
 	}
 	return nil
 }
 
-func commandReadRAM(port io.Writer, address byte) error {
-	return command(port, 20, address, 0)
+// responseError converts an error code sent by the PLI to an error
+func responseError(code byte) error {
+	return &ProtocolError{Code: code}
+}
+
+func (pli *PLI) loopbackTest() error {
+	return pli.exchange(context.Background(), func() error {
+		return pli.transact(cmdLoopbackTest, 0, 0, func(port io.Reader) error {
+			_, err := readResponse(port)
+			if err == nil {
+				return errors.New("Expected one byte response")
+			}
+			if !errors.Is(err, ErrLoopbackResponse) {
+				return err
+			}
+			return nil
+		})
+	})
+}
+
+const cmdReadRAM = 20
+const cmdReadEEPROM = 72
+const cmdShortPush = 87
+const cmdLongPush = 92
+const cmdWriteRAM = 152
+const cmdLoopbackTest = 187
+const cmdWriteEEPROM = 202
+
+func commandName(command byte) string {
+	switch command {
+	case cmdReadRAM:
+		return "read RAM"
+	case cmdReadEEPROM:
+		return "read EEPROM"
+	case cmdShortPush:
+		return "short push"
+	case cmdLongPush:
+		return "long push"
+	case cmdWriteRAM:
+		return "write RAM"
+	case cmdLoopbackTest:
+		return "loopback test"
+	case cmdWriteEEPROM:
+		return "write EEPROM"
+	default:
+		return fmt.Sprintf("command %v", command)
+	}
 }
+
 func commandLoopbackTest(port io.Writer) error {
-	return command(port, 187, 0, 0)
+	return command(port, cmdLoopbackTest, 0, 0)
 }
 
 func command(port io.Writer, command byte, address byte, value byte) error {
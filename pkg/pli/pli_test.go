@@ -2,7 +2,14 @@ package pli
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -26,3 +33,181 @@ func TestRounding(t *testing.T) {
 	var b byte = 52
 	assert.Equal(t, float32(20.8), float32(b)*2/5)
 }
+
+func TestWriteRAM(t *testing.T) {
+	port := &fakePort{}
+	port.responses.Write([]byte{200})
+	pli := PLI{Port: port}
+	err := pli.WriteRAM(47, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{152, 47, 3, 103}, port.written.Bytes())
+}
+
+func TestReadAck(t *testing.T) {
+	assert.Nil(t, readAck(bytes.NewBuffer([]byte{200})))
+	assert.True(t, errors.Is(readAck(bytes.NewBuffer([]byte{129})), ErrTimeout))
+	assert.Equal(t, ErrTimeout, readAck(timedOutReader{}))
+}
+
+// timedOutReader is like a serial port where the read timed out without anything arriving
+type timedOutReader struct{}
+
+func (timedOutReader) Read(b []byte) (int, error) { return 0, nil }
+
+func TestReadResponseNothing(t *testing.T) {
+	_, err := readResponse(timedOutReader{})
+	assert.Equal(t, ErrTimeout, err)
+}
+
+func TestReadEEPROM(t *testing.T) {
+	port := &fakePort{}
+	port.responses.Write([]byte{200, 44})
+	pli := PLI{Port: port}
+	b, err := pli.ReadEEPROM(94)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(44), b)
+	assert.Equal(t, []byte{72, 94, 0, 183}, port.written.Bytes())
+}
+
+func TestResponseError(t *testing.T) {
+	_, err := readResponse(bytes.NewBuffer([]byte{130}))
+	assert.True(t, errors.Is(err, ErrChecksum))
+	assert.True(t, IsTransient(err))
+	_, err = readResponse(bytes.NewBuffer([]byte{99}))
+	assert.True(t, errors.Is(err, ErrUnknownCode))
+	assert.False(t, IsTransient(err))
+	var perr *ProtocolError
+	assert.True(t, errors.As(err, &perr))
+	assert.Equal(t, byte(99), perr.Code)
+}
+
+func TestProtocolErrorAddress(t *testing.T) {
+	port := &fakePort{}
+	port.responses.Write([]byte{131})
+	pli := PLI{Port: port}
+	_, err := pli.ReadRAM(50)
+	assert.EqualError(t, err, "PLI Error: Command received by PLI is not recognised (read RAM address 50)")
+	var perr *ProtocolError
+	assert.True(t, errors.As(err, &perr))
+	assert.Equal(t, byte(131), perr.Code)
+	assert.Equal(t, byte(50), perr.Address)
+	// Not transient so it shouldn't have been retried
+	assert.Equal(t, 4, port.written.Len())
+}
+
+func TestReadRAMContextCancelled(t *testing.T) {
+	port := &fakePort{}
+	pli := PLI{Port: port}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := pli.ReadRAMContext(ctx, 50)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 0, port.written.Len())
+}
+
+func TestReadRAMContextDeadline(t *testing.T) {
+	// The other end of the pipe is a PLI that never answers
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go io.Copy(ioutil.Discard, server)
+
+	pli := PLI{Port: client}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := pli.ReadRAMContext(ctx, 50)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+// ramPort behaves like a PLI where every RAM address holds its own address as a value.
+// It dribbles out the response a byte at a time to give other goroutines a chance to interfere.
+type ramPort struct {
+	mu        sync.Mutex
+	responses []byte
+}
+
+func (p *ramPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.responses = append(p.responses, 200, b[1])
+	return len(b), nil
+}
+
+func (p *ramPort) Read(b []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.responses) == 0 {
+		return 0, io.EOF
+	}
+	b[0] = p.responses[0]
+	p.responses = p.responses[1:]
+	return 1, nil
+}
+
+func (p *ramPort) Close() error { return nil }
+
+func TestReadRAMConcurrently(t *testing.T) {
+	pli := PLI{Port: &ramPort{}}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(address byte) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				b, err := pli.ReadRAM(address)
+				assert.Nil(t, err)
+				assert.Equal(t, address, b)
+			}
+		}(byte(i))
+	}
+	wg.Wait()
+}
+
+// latePort is a PLI where every RAM address holds its own address as a value but the
+// response to the first command only turns up after we've given up waiting for it
+type latePort struct {
+	ramPort
+	commands int
+}
+
+func (p *latePort) Write(b []byte) (int, error) {
+	p.commands++
+	return p.ramPort.Write(b)
+}
+
+func (p *latePort) Read(b []byte) (int, error) {
+	if p.commands == 1 && len(p.responses) == 2 {
+		// Not there yet
+		p.commands++
+		return 0, io.EOF
+	}
+	return p.ramPort.Read(b)
+}
+
+func TestLateResponseIsThrownAway(t *testing.T) {
+	pli := PLI{Port: &latePort{}}
+	_, err := pli.ReadRAM(50)
+	assert.Equal(t, io.EOF, err)
+	b, err := pli.ReadRAM(51)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(51), b)
+}
+
+func TestReadTwoBytesWhileChanging(t *testing.T) {
+	port := &fakePort{}
+	// The value goes from 0x80ff to 0x8100 between reading the high and low bytes
+	port.responses.Write([]byte{200, 0x80, 200, 0x00, 200, 0x81, 200, 0x00, 200, 0x81})
+	pli := PLI{Port: port}
+	v, err := pli.readRAMTwoBytes(context.Background(), 220, 221)
+	assert.Nil(t, err)
+	assert.Equal(t, 0x8100, v)
+
+	port = &fakePort{}
+	port.responses.Write([]byte{200, 1, 200, 0, 200, 2, 200, 0, 200, 3, 200, 0, 200, 4})
+	pli = PLI{Port: port}
+	_, err = pli.readRAMTwoBytes(context.Background(), 220, 221)
+	assert.Equal(t, ErrValueChanging, err)
+}
@@ -1,6 +1,7 @@
 package pli
 
 import (
+	"context"
 	"errors"
 	"time"
 )
@@ -16,6 +17,8 @@ import (
 // batv - 50 - Battery voltage in 0.1V steps scaled relative to 12V.eg. 128=12.8V, for 24V system 128*2=25.6V, for 48V system128*4=51.2V
 // volt - 93 - msn= Prog number (0-4), lsn=system voltage (0-4)System voltage... 0=12V, 1=24V, 2=32V, 3=36V, 4=48VEg. 00110001 = 24V system running Prog 3.
 // bcap - 94 - battery capacity in 20/100 Ah chunks
+// bminl - 124 - lower byte of battery min voltage scaled to 12V
+// bmaxl - 125 - lower byte of battery max voltage scaled to 12V
 // dsoc- 181 - SOC (day data state of charge)
 // ciahl - 188 - Internal charge ah low byte
 // ciahh - 189 - Internal charge ah high byte
@@ -28,29 +31,31 @@ import (
 // cext - 205 - external charge input (NOTE: First read ‘extf’ to check validity andscaling)
 // lext - 206 - external load input (NOTE: First read ‘extf’ to check validity andscaling)
 // extf - 207 - external flag and scale file - Bit 3, Enable of LEXT. - Bit 2, Enable for CEXT - Bit 1, 1=1A/step for LEXT (times 10), 0=0.1A/step for LEXT - Bit 0, 1=1A/step for CEXT (times 10), 0=0.1A/step for CEXT
+// vext - 208 - external voltage reading 0-255 volt 1V steps
 // cint - 213 - Internal (solar) charge current:0.1A steps for PL20 (eg. 10=1.0 Amp solar charge)0.2A steps for PL40 (eg. 10=2.0 Amps solar charge)0.4A steps for PL60 (eg. 10=4.0 Amps solar charge)
 // lint - 217 - Internal LOAD- current:0.1A steps for PL20/PL40 (eg. 10=1.0A), 0.2A steps for PL60 (eg.10=2.0A)
 //
-// TODO:
-// solv - 53  - solar voltage msb
-// bminl - 124 - lower byte of battery min voltage scaled to 12V
-// bmaxl - 125 - lower byte of battery max voltage scaled to 12V
-// vext - 208 - external voltage reading 0-255 volt 1V steps
+// Guessed (the scaling isn't documented):
 // batvl - 220 - battery voltage lsb
 // vbat - 221 - battery voltage msb
+//
+// TODO:
+// solv - 53  - solar voltage msb
 // solvl - 232 - solar voltage lsb
 // vsol - 233 - solar voltage msb
 
 // Reading the solar voltage is complicated because the charging needs to be stopped and the
-// display activated to get an accurate reading
+// display activated to get an accurate reading. There's no documented command to pause
+// charging and be sure it starts again, so the solar voltage isn't read at all.
 
 const PL20 = "PL20"
 const PL40 = "PL40"
 const PL60 = "PL60"
 const PL80 = "PL80"
 
-func (pli *PLI) softwareVersion() (string, byte, error) {
-	value, err := pli.ReadRAM(0)
+func (pli *PLI) softwareVersion(ctx context.Context) (string, byte, error) {
+	raw, err := pli.readRawByName(ctx, "ver")
+	value := byte(raw)
 	if err != nil {
 		return "", value, err
 	}
@@ -68,7 +73,12 @@ func (pli *PLI) softwareVersion() (string, byte, error) {
 // Time returns the time (to the nearest 2 seconds) as stored in the PLI. This is used internally to
 // total things over the day. So, it's fairly important that it's roughly correct.
 func (pli *PLI) Time() (hour int, min int, sec int, err error) {
-	a, err := pli.ReadRAM(46) // 2 second chunks
+	return pli.TimeContext(context.Background())
+}
+
+// TimeContext is the same as Time but takes a context
+func (pli *PLI) TimeContext(ctx context.Context) (hour int, min int, sec int, err error) {
+	a, err := pli.readRawByName(ctx, "sec") // 2 second chunks
 	if err != nil {
 		return
 	}
@@ -76,7 +86,7 @@ func (pli *PLI) Time() (hour int, min int, sec int, err error) {
 		err = errors.New("Expected 'seconds' byte to be in the range 0-29")
 		return
 	}
-	b, err := pli.ReadRAM(47) // minute chunks (0-5)
+	b, err := pli.readRawByName(ctx, "min") // minute chunks (0-5)
 	if err != nil {
 		return
 	}
@@ -84,14 +94,14 @@ func (pli *PLI) Time() (hour int, min int, sec int, err error) {
 		err = errors.New("Expected 'minute' byte to be in the range 0-5")
 		return
 	}
-	c, err := pli.ReadRAM(48) // 6 minute chunks
+	c, err := pli.readRawByName(ctx, "hour") // 6 minute chunks
 	if err != nil {
 		return
 	}
 	if c > 239 {
 		err = errors.New("Expected 'hour' byte to be in the range 0-239")
 	}
-	sec = int(c)*6*60 + int(b)*60 + int(a)*2
+	sec = c*6*60 + b*60 + a*2
 	min = sec / 60
 	sec = sec % 60
 	hour = min / 60
@@ -102,7 +112,12 @@ func (pli *PLI) Time() (hour int, min int, sec int, err error) {
 // CheckTime gets the time as stored in the PLI but will also error if it's too
 // different from the "real" time as known by the computer
 func (pli *PLI) CheckTime() (hour int, min int, sec int, err error) {
-	hour, min, sec, err = pli.Time()
+	return pli.CheckTimeContext(context.Background())
+}
+
+// CheckTimeContext is the same as CheckTime but takes a context
+func (pli *PLI) CheckTimeContext(ctx context.Context) (hour int, min int, sec int, err error) {
+	hour, min, sec, err = pli.TimeContext(ctx)
 	if err != nil {
 		return
 	}
@@ -119,35 +134,68 @@ func (pli *PLI) CheckTime() (hour int, min int, sec int, err error) {
 	return
 }
 
-func (pli *PLI) byteToVoltage(b byte) float32 {
-	return float32(b) * float32(pli.Voltage) / 10 / 12
+// TODO: This gives a slightly different reading to what the PL80 is showing (out by 0.1V).
+// PreciseBatteryVoltage might be closer.
+func (pli *PLI) BatteryVoltage() (float32, error) {
+	return pli.BatteryVoltageContext(context.Background())
 }
 
-// TODO: This gives a slightly different reading to what the PL80 is showing (out by 0.1V)
-func (pli *PLI) BatteryVoltage() (float32, error) {
-	b, err := pli.ReadRAM(50)
-	return pli.byteToVoltage(b), err
+// BatteryVoltageContext is the same as BatteryVoltage but takes a context
+func (pli *PLI) BatteryVoltageContext(ctx context.Context) (float32, error) {
+	v, err := pli.ReadRegisterContext(ctx, "batv")
+	return float32(v), err
+}
+
+// PreciseBatteryVoltage returns the battery voltage with a higher resolution than
+// BatteryVoltage. It takes at least three reads instead of one. The scaling isn't documented
+// so it's only a guess.
+func (pli *PLI) PreciseBatteryVoltage() (float32, error) {
+	return pli.PreciseBatteryVoltageContext(context.Background())
+}
+
+// PreciseBatteryVoltageContext is the same as PreciseBatteryVoltage but takes a context
+func (pli *PLI) PreciseBatteryVoltageContext(ctx context.Context) (float32, error) {
+	v, err := pli.ReadRegisterContext(ctx, "vbat")
+	return float32(v), err
+}
+
+// BatteryMinVoltage returns the lowest battery voltage since midnight
+func (pli *PLI) BatteryMinVoltage() (float32, error) {
+	return pli.BatteryMinVoltageContext(context.Background())
+}
+
+// BatteryMinVoltageContext is the same as BatteryMinVoltage but takes a context
+func (pli *PLI) BatteryMinVoltageContext(ctx context.Context) (float32, error) {
+	v, err := pli.ReadRegisterContext(ctx, "bminl")
+	return float32(v), err
+}
+
+// BatteryMaxVoltage returns the highest battery voltage since midnight
+func (pli *PLI) BatteryMaxVoltage() (float32, error) {
+	return pli.BatteryMaxVoltageContext(context.Background())
+}
+
+// BatteryMaxVoltageContext is the same as BatteryMaxVoltage but takes a context
+func (pli *PLI) BatteryMaxVoltageContext(ctx context.Context) (float32, error) {
+	v, err := pli.ReadRegisterContext(ctx, "bmaxl")
+	return float32(v), err
 }
 
 // BatterCapacity returns the capacity of the battery measured in Ah
 func (pli *PLI) BatteryCapacity() (int, error) {
-	b, err := pli.ReadRAM(94)
-	if err != nil {
-		return 0, err
-	}
-	// Battery capacity setting 20A/100A per step - 20A steps until 1000Ah, 100Ah steps >1000Ah
-	var value int
-	value = int(b) * 20
-	if value > 1000 {
-		value = 1000 + (value-1000)/20*100
-	}
-	return value, nil
+	return pli.BatteryCapacityContext(context.Background())
+}
+
+// BatteryCapacityContext is the same as BatteryCapacity but takes a context
+func (pli *PLI) BatteryCapacityContext(ctx context.Context) (int, error) {
+	v, err := pli.ReadRegisterContext(ctx, "bcap")
+	return int(v), err
 }
 
 // Gets the overall PL program number and the system voltage
-func (pli *PLI) volt() (prog int, voltage int, err error) {
-	v, err := pli.ReadRAM(93)
-	progByte, voltByte := extractNibbles(v)
+func (pli *PLI) volt(ctx context.Context) (prog int, voltage int, err error) {
+	v, err := pli.readRawByName(ctx, "volt")
+	progByte, voltByte := extractNibbles(byte(v))
 	if progByte > 4 {
 		err = errors.New("Expected program number to be in the range 0-4")
 		return
@@ -176,7 +224,12 @@ const RegulatorStateAbsorption = "absorption"
 const RegulatorStateFloat = "float"
 
 func (pli *PLI) RegulatorState() (string, error) {
-	b, err := pli.ReadRAM(101)
+	return pli.RegulatorStateContext(context.Background())
+}
+
+// RegulatorStateContext is the same as RegulatorState but takes a context
+func (pli *PLI) RegulatorStateContext(ctx context.Context) (string, error) {
+	b, err := pli.readRawByName(ctx, "rstate")
 	if err != nil {
 		return "", err
 	}
@@ -197,43 +250,80 @@ func (pli *PLI) RegulatorState() (string, error) {
 // StateOfCharge returns a number between 0 and 100 which is very very roughly a measure of
 // how full the battery is. There are many ways this number can be misleading. So be careful.
 func (pli *PLI) StateOfCharge() (int, error) {
-	b, err := pli.ReadRAM(181)
-	return int(b), err
+	return pli.StateOfChargeContext(context.Background())
+}
+
+// StateOfChargeContext is the same as StateOfCharge but takes a context
+func (pli *PLI) StateOfChargeContext(ctx context.Context) (int, error) {
+	return pli.readRawByName(ctx, "dsoc")
 }
 
 func twoBytes(h byte, l byte) int {
 	return (int(h) << 8) | int(l)
 }
 
-func (pli *PLI) readRAMTwoBytes(la byte, ha byte) (int, error) {
-	l, err := pli.ReadRAM(la)
-	if err != nil {
-		return 0, err
-	}
-	h, err := pli.ReadRAM(ha)
+// How many times to try reading a two byte value before giving up on it staying still
+const maxTwoByteReads = 3
+
+var ErrValueChanging = errors.New("Value kept changing while it was being read")
+
+// readRAMTwoBytes reads a value that is stored in two bytes. They can only be read one at a
+// time so the value might change in between. To catch that the high byte is read again
+// afterwards and if it's changed the low byte is read again too.
+func (pli *PLI) readRAMTwoBytes(ctx context.Context, la byte, ha byte) (int, error) {
+	h, err := pli.ReadRAMContext(ctx, ha)
 	if err != nil {
 		return 0, err
 	}
-	return twoBytes(h, l), nil
+	for i := 0; i < maxTwoByteReads; i++ {
+		l, err := pli.ReadRAMContext(ctx, la)
+		if err != nil {
+			return 0, err
+		}
+		again, err := pli.ReadRAMContext(ctx, ha)
+		if err != nil {
+			return 0, err
+		}
+		if again == h {
+			return twoBytes(h, l), nil
+		}
+		h = again
+	}
+	return 0, ErrValueChanging
 }
 
 // InternalIn returns value as Ah
 func (pli *PLI) InternalIn() (int, error) {
-	return pli.readRAMTwoBytes(188, 189)
+	return pli.InternalInContext(context.Background())
+}
+
+// InternalInContext is the same as InternalIn but takes a context
+func (pli *PLI) InternalInContext(ctx context.Context) (int, error) {
+	return pli.readRawByName(ctx, "ciah")
 }
 
 // ExternalIn returns value as Ah
 func (pli *PLI) ExternalIn() (int, error) {
-	return pli.readRAMTwoBytes(193, 194)
+	return pli.ExternalInContext(context.Background())
+}
+
+// ExternalInContext is the same as ExternalIn but takes a context
+func (pli *PLI) ExternalInContext(ctx context.Context) (int, error) {
+	return pli.readRawByName(ctx, "ceah")
 }
 
 // In returns value as Ah
 func (pli *PLI) In() (int, error) {
-	internal, err := pli.InternalIn()
+	return pli.InContext(context.Background())
+}
+
+// InContext is the same as In but takes a context
+func (pli *PLI) InContext(ctx context.Context) (int, error) {
+	internal, err := pli.InternalInContext(ctx)
 	if err != nil {
 		return 0, err
 	}
-	external, err := pli.ExternalIn()
+	external, err := pli.ExternalInContext(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -242,21 +332,36 @@ func (pli *PLI) In() (int, error) {
 
 // InternalOut returns value as Ah
 func (pli *PLI) InternalOut() (int, error) {
-	return pli.readRAMTwoBytes(198, 199)
+	return pli.InternalOutContext(context.Background())
+}
+
+// InternalOutContext is the same as InternalOut but takes a context
+func (pli *PLI) InternalOutContext(ctx context.Context) (int, error) {
+	return pli.readRawByName(ctx, "liah")
 }
 
 // ExternalOut returns value as Ah
 func (pli *PLI) ExternalOut() (int, error) {
-	return pli.readRAMTwoBytes(203, 204)
+	return pli.ExternalOutContext(context.Background())
+}
+
+// ExternalOutContext is the same as ExternalOut but takes a context
+func (pli *PLI) ExternalOutContext(ctx context.Context) (int, error) {
+	return pli.readRawByName(ctx, "leah")
 }
 
 // Out returns value as Ah
 func (pli *PLI) Out() (int, error) {
-	internal, err := pli.InternalOut()
+	return pli.OutContext(context.Background())
+}
+
+// OutContext is the same as Out but takes a context
+func (pli *PLI) OutContext(ctx context.Context) (int, error) {
+	internal, err := pli.InternalOutContext(ctx)
 	if err != nil {
 		return 0, err
 	}
-	external, err := pli.ExternalOut()
+	external, err := pli.ExternalOutContext(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -265,86 +370,69 @@ func (pli *PLI) Out() (int, error) {
 
 // ExternalCharge returns value in A
 func (pli *PLI) ExternalCharge() (float32, error) {
-	extf, err := pli.ReadRAM(207)
-	if err != nil {
-		return 0, err
-	}
-	// If bit 2 is not set it is disabled so just return zero
-	if extf&0x4 == 0 {
-		return 0, nil
-	}
-	current, err := pli.ReadRAM(205)
-	if err != nil {
-		return 0, err
-	}
-	value := float32(current)
-	if extf&0x1 == 0 {
-		value = value / 10
-	}
-	return value, nil
+	return pli.ExternalChargeContext(context.Background())
+}
+
+// ExternalChargeContext is the same as ExternalCharge but takes a context
+func (pli *PLI) ExternalChargeContext(ctx context.Context) (float32, error) {
+	v, err := pli.ReadRegisterContext(ctx, "cext")
+	return float32(v), err
 }
 
 // ExternalLoad returns value in A
 func (pli *PLI) ExternalLoad() (float32, error) {
-	extf, err := pli.ReadRAM(207)
-	if err != nil {
-		return 0, err
-	}
-	// If bit 3 is not set it is disabled so just return zero
-	if extf&0x8 == 0 {
-		return 0, nil
-	}
-	current, err := pli.ReadRAM(206)
-	if err != nil {
-		return 0, err
-	}
-	value := float32(current)
-	if extf&0x2 == 0 {
-		value = value / 10
-	}
-	return value, nil
+	return pli.ExternalLoadContext(context.Background())
+}
+
+// ExternalLoadContext is the same as ExternalLoad but takes a context
+func (pli *PLI) ExternalLoadContext(ctx context.Context) (float32, error) {
+	v, err := pli.ReadRegisterContext(ctx, "lext")
+	return float32(v), err
+}
+
+// ExternalVoltage returns the voltage on the PL's external voltage input (in 1V steps).
+// What that's measuring depends on what's wired up to it.
+func (pli *PLI) ExternalVoltage() (float32, error) {
+	return pli.ExternalVoltageContext(context.Background())
+}
+
+// ExternalVoltageContext is the same as ExternalVoltage but takes a context
+func (pli *PLI) ExternalVoltageContext(ctx context.Context) (float32, error) {
+	v, err := pli.ReadRegisterContext(ctx, "vext")
+	return float32(v), err
 }
 
 func (pli *PLI) InternalCharge() (float32, error) {
-	v, err := pli.ReadRAM(213)
-	if err != nil {
-		return 0, err
-	}
-	value := float32(v)
-	switch pli.Model {
-	case PL20:
-		value = value / 10
-	case PL40:
-		value = value / 5
-	// Guessing what it is for PL80 - undocumented
-	case PL60, PL80:
-		value = value * 2 / 5
-	}
-	return value, nil
+	return pli.InternalChargeContext(context.Background())
+}
+
+// InternalChargeContext is the same as InternalCharge but takes a context
+func (pli *PLI) InternalChargeContext(ctx context.Context) (float32, error) {
+	v, err := pli.ReadRegisterContext(ctx, "cint")
+	return float32(v), err
 }
 
 func (pli *PLI) InternalLoad() (float32, error) {
-	v, err := pli.ReadRAM(217)
-	if err != nil {
-		return 0, err
-	}
-	value := float32(v)
-	switch pli.Model {
-	case PL20, PL40:
-		value = value / 10
-	// Guessing what it is for PL80 - undocumented
-	case PL60, PL80:
-		value = value / 5
-	}
-	return value, nil
+	return pli.InternalLoadContext(context.Background())
+}
+
+// InternalLoadContext is the same as InternalLoad but takes a context
+func (pli *PLI) InternalLoadContext(ctx context.Context) (float32, error) {
+	v, err := pli.ReadRegisterContext(ctx, "lint")
+	return float32(v), err
 }
 
 func (pli *PLI) Charge() (float32, error) {
-	internal, err := pli.InternalCharge()
+	return pli.ChargeContext(context.Background())
+}
+
+// ChargeContext is the same as Charge but takes a context
+func (pli *PLI) ChargeContext(ctx context.Context) (float32, error) {
+	internal, err := pli.InternalChargeContext(ctx)
 	if err != nil {
 		return 0, err
 	}
-	external, err := pli.ExternalCharge()
+	external, err := pli.ExternalChargeContext(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -352,11 +440,16 @@ func (pli *PLI) Charge() (float32, error) {
 }
 
 func (pli *PLI) Load() (float32, error) {
-	internal, err := pli.InternalLoad()
+	return pli.LoadContext(context.Background())
+}
+
+// LoadContext is the same as Load but takes a context
+func (pli *PLI) LoadContext(ctx context.Context) (float32, error) {
+	internal, err := pli.InternalLoadContext(ctx)
 	if err != nil {
 		return 0, err
 	}
-	external, err := pli.ExternalLoad()
+	external, err := pli.ExternalLoadContext(ctx)
 	if err != nil {
 		return 0, err
 	}
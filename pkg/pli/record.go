@@ -0,0 +1,264 @@
+package pli
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recording everything that goes to and from the PLI so that it can be played back later.
+// This way a capture from a real PLI can become a test.
+//
+// A recording is a text file. After the header there's a line for everything written or read:
+//   > 0.000012 bb 00 00 44
+//   < 0.010311 80
+//   < 1.011002 EOF
+// ">" is written to the PLI and "<" is read from it. The number is the time in seconds since
+// the start of the recording. It's followed by the bytes in hex or the error from the read.
+// Lines starting with "#" are comments, for saying where a recording came from. Recordings
+// can be appended to each other, so the header can turn up again further down.
+
+const recordingHeader = "pli-recording 1"
+
+// Recorder wraps a port and writes everything that goes through it to a recording
+type Recorder struct {
+	Port io.ReadWriteCloser
+
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	err   error // First error writing the recording
+}
+
+// NewRecorder starts recording everything that goes through port to w. If w can be closed
+// (like a file) it's closed along with the port.
+func NewRecorder(port io.ReadWriteCloser, w io.Writer) *Recorder {
+	r := &Recorder{Port: port, w: w, start: time.Now()}
+	_, r.err = fmt.Fprintln(w, recordingHeader)
+	return r
+}
+
+func (r *Recorder) record(direction string, b []byte, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	t := time.Since(r.start).Seconds()
+	var what string
+	if len(b) > 0 {
+		what = hexBytes(b)
+	} else {
+		what = err.Error()
+	}
+	_, r.err = fmt.Fprintf(r.w, "%v %.6f %v\n", direction, t, what)
+}
+
+// Comment adds a comment line to the recording
+func (r *Recorder) Comment(text string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	_, r.err = fmt.Fprintf(r.w, "# %v\n", text)
+}
+
+// Err returns the first error that happened writing the recording
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	n, err := r.Port.Write(b)
+	if n > 0 {
+		r.record(">", b[:n], nil)
+	}
+	return n, err
+}
+
+func (r *Recorder) Read(b []byte) (int, error) {
+	n, err := r.Port.Read(b)
+	if n > 0 || err != nil {
+		r.record("<", b[:n], err)
+	}
+	return n, err
+}
+
+func (r *Recorder) Close() error {
+	err := r.Port.Close()
+	if c, ok := r.w.(io.Closer); ok {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		cerr := c.Close()
+		if err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// SetReadDeadline passes the deadline on to the port if it supports them
+func (r *Recorder) SetReadDeadline(t time.Time) error {
+	d, ok := r.Port.(deadliner)
+	if !ok {
+		return errors.New("Port does not support deadlines")
+	}
+	return d.SetReadDeadline(t)
+}
+
+func hexBytes(b []byte) string {
+	s := make([]string, len(b))
+	for i, c := range b {
+		s[i] = fmt.Sprintf("%02x", c)
+	}
+	return strings.Join(s, " ")
+}
+
+type recordedEvent struct {
+	write bool
+	data  []byte
+	err   error
+}
+
+var ErrRecordingFormat = errors.New("Not a valid PLI recording")
+var ErrReplayMismatch = errors.New("PLI replay does not match recording")
+
+// Replay plays back a recording. Whatever is written to it has to match what was written
+// in the recording and reads get whatever was read in the recording.
+type Replay struct {
+	mu     sync.Mutex
+	events []recordedEvent
+	next   int
+}
+
+// ReadRecording loads a recording made with Recorder so it can be played back
+func ReadRecording(r io.Reader) (*Replay, error) {
+	var replay Replay
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if line == 1 {
+			if text != recordingHeader {
+				return nil, ErrRecordingFormat
+			}
+			continue
+		}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") || text == recordingHeader {
+			continue
+		}
+		event, err := parseEvent(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %v: %v", ErrRecordingFormat, line, err)
+		}
+		replay.events = append(replay.events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if line == 0 {
+		return nil, ErrRecordingFormat
+	}
+	return &replay, nil
+}
+
+func parseEvent(text string) (recordedEvent, error) {
+	var event recordedEvent
+	fields := strings.SplitN(text, " ", 3)
+	if len(fields) != 3 {
+		return event, errors.New("expected direction, time and data")
+	}
+	switch fields[0] {
+	case ">":
+		event.write = true
+	case "<":
+	default:
+		return event, fmt.Errorf("unknown direction %q", fields[0])
+	}
+	// Replays go as fast as they can so the time is only checked
+	_, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return event, err
+	}
+	data, err := hex.DecodeString(strings.Replace(fields[2], " ", "", -1))
+	if err == nil {
+		event.data = data
+	} else if event.write {
+		return event, err
+	} else if fields[2] == io.EOF.Error() {
+		// A read that timed out. Use io.EOF itself so that it behaves the same as a serial port.
+		event.err = io.EOF
+	} else {
+		event.err = errors.New(fields[2])
+	}
+	return event, nil
+}
+
+func (r *Replay) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	written := 0
+	for written < len(b) {
+		if r.next >= len(r.events) || !r.events[r.next].write {
+			return written, fmt.Errorf("%w: unexpected write of %v", ErrReplayMismatch, hexBytes(b[written:]))
+		}
+		event := &r.events[r.next]
+		n := len(event.data)
+		if n > len(b)-written {
+			n = len(b) - written
+		}
+		if string(event.data[:n]) != string(b[written:written+n]) {
+			return written, fmt.Errorf("%w: wrote %v but expected %v", ErrReplayMismatch, hexBytes(b[written:]), hexBytes(event.data))
+		}
+		written += n
+		event.data = event.data[n:]
+		if len(event.data) == 0 {
+			r.next++
+		}
+	}
+	return written, nil
+}
+
+func (r *Replay) Read(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next >= len(r.events) {
+		// The recording has run out. Behave like a PLI that isn't answering.
+		return 0, io.EOF
+	}
+	event := &r.events[r.next]
+	if event.write {
+		return 0, fmt.Errorf("%w: unexpected read while waiting for a write of %v", ErrReplayMismatch, hexBytes(event.data))
+	}
+	if event.err != nil {
+		r.next++
+		return 0, event.err
+	}
+	n := copy(b, event.data)
+	event.data = event.data[n:]
+	if len(event.data) == 0 {
+		r.next++
+	}
+	return n, nil
+}
+
+// Done is true when everything in the recording has been played back
+func (r *Replay) Done() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.next >= len(r.events)
+}
+
+func (r *Replay) Close() error {
+	return nil
+}
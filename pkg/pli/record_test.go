@@ -0,0 +1,114 @@
+package pli
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/plisim"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	var recording bytes.Buffer
+	recorder := NewRecorder(plisim.New(), &recording)
+	pli, err := NewWithPort(recorder)
+	assert.Nil(t, err)
+	_, err = pli.BatteryCapacity()
+	assert.Nil(t, err)
+	assert.Nil(t, recorder.Err())
+
+	replay, err := ReadRecording(&recording)
+	assert.Nil(t, err)
+	pli, err = NewWithPort(replay)
+	assert.Nil(t, err)
+	assert.Equal(t, PL80, pli.Model)
+	bc, err := pli.BatteryCapacity()
+	assert.Nil(t, err)
+	assert.Equal(t, 880, bc)
+	assert.True(t, replay.Done())
+}
+
+// closeBuffer is a recording file that knows whether it was closed
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRecorderClosesRecording(t *testing.T) {
+	var recording closeBuffer
+	recorder := NewRecorder(plisim.New(), &recording)
+	assert.Nil(t, recorder.Close())
+	assert.True(t, recording.closed)
+}
+
+func TestRecordingComments(t *testing.T) {
+	replay, err := ReadRecording(strings.NewReader("pli-recording 1\n# Where this came from\n> 0.0 bb 00 00 44\n"))
+	assert.Nil(t, err)
+	_, err = replay.Write([]byte{187, 0, 0, 68})
+	assert.Nil(t, err)
+	assert.True(t, replay.Done())
+}
+
+func TestAppendedRecordings(t *testing.T) {
+	var buf bytes.Buffer
+	for i := 0; i < 2; i++ {
+		recorder := NewRecorder(plisim.New(), &buf)
+		recorder.Comment("Connected")
+		_, err := recorder.Write([]byte{187, 0, 0, 68})
+		assert.Nil(t, err)
+	}
+	assert.True(t, strings.HasPrefix(buf.String(), "pli-recording 1\n# Connected\n> "))
+
+	replay, err := ReadRecording(&buf)
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = replay.Write([]byte{187, 0, 0, 68})
+		assert.Nil(t, err)
+	}
+	assert.True(t, replay.Done())
+}
+
+// Regression test from a saved recording which includes a checksum error that gets retried.
+// The recording was made with plisim rather than captured from a real PL.
+func TestReplayPL80(t *testing.T) {
+	f, err := os.Open("testdata/pl80.rec")
+	assert.Nil(t, err)
+	defer f.Close()
+	replay, err := ReadRecording(f)
+	assert.Nil(t, err)
+
+	// Checksum errors are only retried when asked for
+	pli, err := NewWithPort(replay, WithRetryPolicy(RetryPolicy{MaxAttempts: 5, Codes: []byte{129, 130}}))
+	assert.Nil(t, err)
+	assert.Equal(t, 24, pli.Voltage)
+	v, err := pli.BatteryVoltage()
+	assert.Nil(t, err)
+	assert.Equal(t, float32(25.6), v)
+	soc, err := pli.StateOfCharge()
+	assert.Nil(t, err)
+	assert.Equal(t, 95, soc)
+	assert.True(t, replay.Done())
+}
+
+func TestReplayMismatch(t *testing.T) {
+	replay, err := ReadRecording(strings.NewReader("pli-recording 1\n> 0.0 bb 00 00 44\n< 0.1 EOF\n"))
+	assert.Nil(t, err)
+	_, err = replay.Write([]byte{20, 50, 0, 235})
+	assert.True(t, errors.Is(err, ErrReplayMismatch))
+
+	replay, err = ReadRecording(strings.NewReader("pli-recording 1\n> 0.0 bb 00 00 44\n< 0.1 EOF\n"))
+	assert.Nil(t, err)
+	_, err = replay.Write([]byte{187, 0, 0, 68})
+	assert.Nil(t, err)
+	_, err = replay.Read(make([]byte, 2))
+	assert.Equal(t, io.EOF, err)
+}
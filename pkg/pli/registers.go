@@ -0,0 +1,269 @@
+package pli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Everything we know about the PL's RAM in one place. The readers in read.go get their
+// addresses and scaling from here so that a new register is a new entry in the table.
+
+// Register is a value stored in the PL's RAM
+type Register struct {
+	Name        string // Name used in the PL documentation
+	Description string
+	Address     byte // For a two byte value this is where the low byte is
+	Width       int  // Number of bytes (1 or 2)
+	HighAddress byte // Where the high byte of a two byte value is
+	// ByteNames are the names of the low and high bytes of a two byte value
+	ByteNames [2]string
+	Units     string // Units of the scaled value. Empty if it's just a number.
+	// Scaling is how the raw value is converted to Units
+	Scaling
+	// Models overrides Scaling for particular models of PL
+	Models map[string]Scaling
+	// SystemVoltage is true for voltages that are stored scaled relative to a 12V system
+	SystemVoltage bool
+	// EnabledBy is a bit that has to be set in another register for this one to be valid.
+	// If it isn't set the value is zero.
+	EnabledBy *Flag
+	// Coarse is a bit in another register that, when set, makes the steps 10 times bigger
+	Coarse *Flag
+	// Decode is for values that aren't simply scaled. It's used instead of Scaling.
+	Decode func(raw int) float64
+	// Setting is the name on the PL's SET menu of the setting stored here (EEPROMSettings only)
+	Setting string
+}
+
+// Scaling is how the raw value of a register is scaled
+type Scaling struct {
+	Step  float64 // Size of each step of the raw value in Units. Zero means 1.
+	Guess bool    // Not documented so the step is a guess
+}
+
+// Flag is a bit in another register
+type Flag struct {
+	Register string
+	Mask     byte
+}
+
+var ErrUnknownRegister = errors.New("Unknown register")
+
+// Registers is every register in RAM that we know something about, in address order
+var Registers = []Register{
+	{Name: "ver", Address: 0, Width: 1, Description: "Software version number. Also tells us the model of PL."},
+	{Name: "sec", Address: 46, Width: 1, Units: "s", Scaling: Scaling{Step: 2}, Description: "Seconds, incremented every 2 seconds (0-29)"},
+	{Name: "min", Address: 47, Width: 1, Units: "min", Description: "Minutes (0-5). Used for the 6 minute timer."},
+	{Name: "hour", Address: 48, Width: 1, Units: "h", Scaling: Scaling{Step: 0.1}, Description: "Current time in 0.1 hour (6 minute) steps (0-239)"},
+	{Name: "batv", Address: 50, Width: 1, Units: "V", Scaling: Scaling{Step: 0.1}, SystemVoltage: true, Description: "Battery voltage"},
+	{Name: "solv", Address: 53, Width: 1, Description: "Solar voltage msb"},
+	{Name: "volt", Address: 93, Width: 1, Description: "Program number (msn) and system voltage (lsn)"},
+	{Name: "bcap", Address: 94, Width: 1, Units: "Ah", Decode: decodeBatteryCapacity, Description: "Battery capacity"},
+	{Name: "rstate", Address: 101, Width: 1, Description: "Regulator state in the bottom two bits"},
+	// These are documented as the lower byte of a voltage scaled to 12V. Like batv that's in
+	// 0.1V steps, so the low byte on its own goes up to 25.5V. A 12V battery never gets near
+	// that, so the high byte is always zero and the low byte is the whole value.
+	{Name: "bminl", Address: 124, Width: 1, Units: "V", Scaling: Scaling{Step: 0.1}, SystemVoltage: true, Description: "Lowest battery voltage today"},
+	{Name: "bmaxl", Address: 125, Width: 1, Units: "V", Scaling: Scaling{Step: 0.1}, SystemVoltage: true, Description: "Highest battery voltage today"},
+	{Name: "dsoc", Address: 181, Width: 1, Units: "%", Description: "State of charge from the day data"},
+	{Name: "ciah", Address: 188, Width: 2, HighAddress: 189, ByteNames: [2]string{"ciahl", "ciahh"}, Units: "Ah", Description: "Internal charge today"},
+	{Name: "ceah", Address: 193, Width: 2, HighAddress: 194, ByteNames: [2]string{"ceahl", "ceahh"}, Units: "Ah", Description: "External charge today"},
+	{Name: "liah", Address: 198, Width: 2, HighAddress: 199, ByteNames: [2]string{"liahl", "liahh"}, Units: "Ah", Description: "Internal load today"},
+	{Name: "leah", Address: 203, Width: 2, HighAddress: 204, ByteNames: [2]string{"leahl", "leahh"}, Units: "Ah", Description: "External load today"},
+	{Name: "cext", Address: 205, Width: 1, Units: "A", Scaling: Scaling{Step: 0.1}, EnabledBy: &Flag{"extf", 0x4}, Coarse: &Flag{"extf", 0x1}, Description: "External charge current"},
+	{Name: "lext", Address: 206, Width: 1, Units: "A", Scaling: Scaling{Step: 0.1}, EnabledBy: &Flag{"extf", 0x8}, Coarse: &Flag{"extf", 0x2}, Description: "External load current"},
+	{Name: "extf", Address: 207, Width: 1, Description: "Flags for enabling and scaling cext and lext"},
+	{Name: "vext", Address: 208, Width: 1, Units: "V", Description: "External voltage input"},
+	{Name: "cint", Address: 213, Width: 1, Units: "A", Description: "Internal (solar) charge current", Models: map[string]Scaling{
+		PL20: {Step: 0.1},
+		PL40: {Step: 0.2},
+		PL60: {Step: 0.4},
+		PL80: {Step: 0.4, Guess: true},
+	}},
+	{Name: "lint", Address: 217, Width: 1, Units: "A", Description: "Internal load current", Models: map[string]Scaling{
+		PL20: {Step: 0.1},
+		PL40: {Step: 0.1},
+		PL60: {Step: 0.2},
+		PL80: {Step: 0.2, Guess: true},
+	}},
+	// Guessing that the msb is in 0.1V steps like batv and the lsb is fractions of a step
+	{Name: "vbat", Address: 220, Width: 2, HighAddress: 221, ByteNames: [2]string{"batvl", "vbat"}, Units: "V", Scaling: Scaling{Step: 0.1 / 256, Guess: true}, SystemVoltage: true, Description: "Battery voltage (high resolution)"},
+	{Name: "solvl", Address: 232, Width: 1, Description: "Solar voltage lsb"},
+	{Name: "vsol", Address: 233, Width: 1, Description: "Solar voltage msb"},
+}
+
+// Battery capacity setting 20A/100A per step - 20A steps until 1000Ah, 100Ah steps >1000Ah
+func decodeBatteryCapacity(raw int) float64 {
+	value := raw * 20
+	if value > 1000 {
+		value = 1000 + (value-1000)/20*100
+	}
+	return float64(value)
+}
+
+// LookupRegister finds a register by name
+func LookupRegister(name string) (Register, bool) {
+	for _, r := range Registers {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Register{}, false
+}
+
+// EEPROMSettings are where the PL keeps its settings in EEPROM. None of these addresses are
+// documented. They can be found by comparing backups from before and after changing a
+// setting on the SET menu.
+//
+// TODO: Find these so that the settings can be read:
+// GRUN - generator run time
+// GDAY - generator days
+// Boost, absorption, float and equalise voltages
+// Load disconnect and reconnect voltages
+var EEPROMSettings = []Register{}
+
+// LookupEEPROMSetting finds the setting stored at an address in EEPROM
+func LookupEEPROMSetting(address byte) (Register, bool) {
+	for _, r := range EEPROMSettings {
+		if r.Address == address {
+			return r, true
+		}
+	}
+	return Register{}, false
+}
+
+// RegisterName returns the name of an address in RAM or an empty string if we don't know what it is
+func RegisterName(address byte) string {
+	for _, r := range Registers {
+		if r.Width == 2 {
+			if r.Address == address {
+				return r.ByteNames[0]
+			}
+			if r.HighAddress == address {
+				return r.ByteNames[1]
+			}
+		} else if r.Address == address {
+			return r.Name
+		}
+	}
+	return ""
+}
+
+// ScalingFor returns how the register is scaled on a particular model of PL
+func (r Register) ScalingFor(model string) Scaling {
+	if scaling, ok := r.Models[model]; ok {
+		return scaling
+	}
+	return r.Scaling
+}
+
+// Scale converts a raw value to Units for a particular model of PL and system voltage. On
+// a model that the register doesn't have a scaling for the raw value is returned.
+func (r Register) Scale(raw int, model string, voltage int) float64 {
+	if r.Decode != nil {
+		return r.Decode(raw)
+	}
+	step := r.ScalingFor(model).Step
+	if step == 0 {
+		step = 1
+	}
+	value := float64(raw) * step
+	if r.SystemVoltage {
+		value = value * float64(voltage) / 12
+	}
+	return value
+}
+
+// ReadRegister reads a register by name and returns its value scaled to its units
+func (pli *PLI) ReadRegister(name string) (float64, error) {
+	return pli.ReadRegisterContext(context.Background(), name)
+}
+
+// ReadRegisterContext is the same as ReadRegister but takes a context
+func (pli *PLI) ReadRegisterContext(ctx context.Context, name string) (float64, error) {
+	r, err := lookupRegister(name)
+	if err != nil {
+		return 0, err
+	}
+	return pli.readRegister(ctx, r)
+}
+
+func (pli *PLI) readRegister(ctx context.Context, r Register) (float64, error) {
+	scale := 1.0
+	if r.EnabledBy != nil || r.Coarse != nil {
+		enabled, coarse, err := pli.readFlags(ctx, r)
+		if err != nil {
+			return 0, err
+		}
+		// If it's not enabled just return zero
+		if !enabled {
+			return 0, nil
+		}
+		if coarse {
+			scale = 10
+		}
+	}
+	raw, err := pli.readRaw(ctx, r)
+	if err != nil {
+		return 0, err
+	}
+	return r.Scale(raw, pli.Model, pli.Voltage) * scale, nil
+}
+
+// readFlags reads the bits that say whether a register is enabled and how it's scaled
+func (pli *PLI) readFlags(ctx context.Context, r Register) (enabled bool, coarse bool, err error) {
+	// Both flags are usually in the same register so only read it once
+	values := make(map[string]byte)
+	isSet := func(f *Flag) (bool, error) {
+		b, ok := values[f.Register]
+		if !ok {
+			raw, err := pli.readRawByName(ctx, f.Register)
+			if err != nil {
+				return false, err
+			}
+			b = byte(raw)
+			values[f.Register] = b
+		}
+		return b&f.Mask != 0, nil
+	}
+
+	enabled = true
+	if r.EnabledBy != nil {
+		enabled, err = isSet(r.EnabledBy)
+		if err != nil || !enabled {
+			return
+		}
+	}
+	if r.Coarse != nil {
+		coarse, err = isSet(r.Coarse)
+	}
+	return
+}
+
+// readRaw reads the unscaled value of a register
+func (pli *PLI) readRaw(ctx context.Context, r Register) (int, error) {
+	if r.Width == 2 {
+		return pli.readRAMTwoBytes(ctx, r.Address, r.HighAddress)
+	}
+	b, err := pli.ReadRAMContext(ctx, r.Address)
+	return int(b), err
+}
+
+// readRawByName reads the unscaled value of a register in the table
+func (pli *PLI) readRawByName(ctx context.Context, name string) (int, error) {
+	r, err := lookupRegister(name)
+	if err != nil {
+		return 0, err
+	}
+	return pli.readRaw(ctx, r)
+}
+
+// lookupRegister is the same as LookupRegister but returns an error if there's no such register
+func lookupRegister(name string) (Register, error) {
+	r, ok := LookupRegister(name)
+	if !ok {
+		return r, fmt.Errorf("%w: %v", ErrUnknownRegister, name)
+	}
+	return r, nil
+}
@@ -0,0 +1,84 @@
+package pli
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/plisim"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistersInAddressOrder(t *testing.T) {
+	names := make(map[string]bool)
+	for i, r := range Registers {
+		if i > 0 {
+			assert.True(t, Registers[i-1].Address < r.Address, r.Name)
+		}
+		assert.False(t, names[r.Name], "duplicate %v", r.Name)
+		names[r.Name] = true
+		assert.Contains(t, []int{1, 2}, r.Width, r.Name)
+	}
+}
+
+func TestLookupRegister(t *testing.T) {
+	r, ok := LookupRegister("batv")
+	assert.True(t, ok)
+	assert.Equal(t, byte(50), r.Address)
+	assert.Equal(t, "V", r.Units)
+	_, ok = LookupRegister("foo")
+	assert.False(t, ok)
+}
+
+func TestRegisterName(t *testing.T) {
+	assert.Equal(t, "batv", RegisterName(50))
+	assert.Equal(t, "ciahl", RegisterName(188))
+	assert.Equal(t, "ciahh", RegisterName(189))
+	assert.Equal(t, "", RegisterName(77))
+}
+
+func TestRegisterScale(t *testing.T) {
+	batv, _ := LookupRegister("batv")
+	assert.InDelta(t, 51.2, batv.Scale(128, PL80, 48), 0.0001)
+
+	cint, _ := LookupRegister("cint")
+	assert.InDelta(t, 2.0, cint.Scale(10, PL40, 12), 0.0001)
+	assert.True(t, cint.ScalingFor(PL80).Guess)
+	assert.False(t, cint.ScalingFor(PL60).Guess)
+	// Not scaled at all on a model we don't know about
+	assert.Equal(t, 10.0, cint.Scale(10, "", 12))
+
+	vbat, _ := LookupRegister("vbat")
+	assert.True(t, vbat.ScalingFor(PL80).Guess)
+
+	bcap, _ := LookupRegister("bcap")
+	assert.Equal(t, 2000.0, bcap.Scale(60, PL80, 12))
+}
+
+func TestReadRegisterWithSim(t *testing.T) {
+	sim := plisim.New()
+	sim.SetRAM(188, 0x34)
+	sim.SetRAM(189, 0x12)
+	sim.SetRAM(205, 25)
+	pli, err := NewWithPort(sim)
+	assert.Nil(t, err)
+
+	v, err := pli.ReadRegister("ciah")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0x1234), v)
+
+	// External charge is disabled until the flag is set
+	v, err = pli.ReadRegister("cext")
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, v)
+	sim.SetRAM(207, 0x4)
+	v, err = pli.ReadRegister("cext")
+	assert.Nil(t, err)
+	assert.InDelta(t, 2.5, v, 0.0001)
+	sim.SetRAM(207, 0x5)
+	v, err = pli.ReadRegister("cext")
+	assert.Nil(t, err)
+	assert.InDelta(t, 25, v, 0.0001)
+
+	_, err = pli.ReadRegister("foo")
+	assert.True(t, errors.Is(err, ErrUnknownRegister))
+}
@@ -0,0 +1,163 @@
+package pli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// What to do when a command to the PLI fails. A long noisy cable needs more patience than a
+// PLI sitting on the bench.
+
+// RetryPolicy decides which failed commands are sent again and how long to wait in between
+type RetryPolicy struct {
+	MaxAttempts    int           // Number of times a command is sent, including the first
+	InitialBackoff time.Duration // Wait after the first failure
+	MaxBackoff     time.Duration // The wait never gets longer than this
+	Multiplier     float64       // The wait is multiplied by this after each failure (if more than 1)
+	// Jitter randomly varies each wait by up to this fraction of it (0 to 1) so that retries
+	// don't fall into step with whatever is causing the problem
+	Jitter float64
+	// Budget is the most time that's spent on a command including all its retries. An
+	// attempt that's still going when it runs out is cut short (if the port supports
+	// deadlines). Zero means there's no limit other than MaxAttempts.
+	Budget time.Duration
+	// Codes are the error codes sent back by the PLI that are worth retrying. If it's nil
+	// only a timeout (129) is retried. The other transient codes (see ProtocolError.Transient)
+	// have to be asked for here because retrying a write after them isn't always harmless.
+	Codes []byte
+	// RetryNoResponse also retries when nothing at all comes back from the PLI before the
+	// port times out
+	RetryNoResponse bool
+}
+
+// DefaultRetryPolicy is used by a PLI that doesn't have its own RetryPolicy. A command is
+// tried up to five times, a second apart.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Second,
+	Multiplier:     1,
+}
+
+// The PLI's code for a timeout, which is all that's retried unless a RetryPolicy says otherwise
+const codeTimeout = 129
+
+// Retryable is true if a command that failed with err is worth sending again
+func (p *RetryPolicy) Retryable(err error) bool {
+	var perr *ProtocolError
+	if !errors.As(err, &perr) {
+		// ErrTimeout on its own (rather than as a code from the PLI) means that the port
+		// timed out without anything arriving
+		return p.RetryNoResponse && (errors.Is(err, io.EOF) || errors.Is(err, ErrTimeout))
+	}
+	codes := p.Codes
+	if codes == nil {
+		codes = []byte{codeTimeout}
+	}
+	for _, code := range codes {
+		if code == perr.Code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff is how long to wait after the given number of failed attempts. random is
+// between 0 and 1.
+func (p *RetryPolicy) backoff(failures int, random float64) time.Duration {
+	wait := float64(p.InitialBackoff)
+	for i := 1; i < failures && p.Multiplier > 1; i++ {
+		wait *= p.Multiplier
+		if wait > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if wait > float64(p.MaxBackoff) {
+		wait = float64(p.MaxBackoff)
+	}
+	wait *= 1 + p.Jitter*(2*random-1)
+	return time.Duration(wait)
+}
+
+// RetryStats counts what happened with the commands sent to the PLI. Use WithRetryStats to
+// collect them for a call. They aren't safe to share between goroutines.
+type RetryStats struct {
+	Commands int           // Number of commands
+	Attempts int           // Number of times commands were sent, including retries
+	Waited   time.Duration // Total time spent waiting before retries
+	Errors   []error       // The error from each failed attempt
+}
+
+// Retries is the number of times that commands were sent again
+func (s *RetryStats) Retries() int {
+	return s.Attempts - s.Commands
+}
+
+type retryStatsKey struct{}
+
+// WithRetryStats returns a context that collects statistics in stats about every command
+// sent using it. For example:
+//
+//	var stats pli.RetryStats
+//	v, err := p.BatteryVoltageContext(pli.WithRetryStats(ctx, &stats))
+func WithRetryStats(ctx context.Context, stats *RetryStats) context.Context {
+	return context.WithValue(ctx, retryStatsKey{}, stats)
+}
+
+func retryStatsFrom(ctx context.Context) *RetryStats {
+	stats, _ := ctx.Value(retryStatsKey{}).(*RetryStats)
+	return stats
+}
+
+func (pli *PLI) retryPolicy() *RetryPolicy {
+	if pli.RetryPolicy == nil {
+		return &DefaultRetryPolicy
+	}
+	return pli.RetryPolicy
+}
+
+// retry calls f until it succeeds or the retry policy gives up. Waiting is cut short when
+// the context is done. If the policy has a budget, f gets a context with a deadline at the
+// end of it so that a slow attempt can't go over. If that happens the error is
+// context.DeadlineExceeded.
+func (pli *PLI) retry(ctx context.Context, f func(ctx context.Context) error) (err error) {
+	policy := pli.retryPolicy()
+	stats := retryStatsFrom(ctx)
+	if stats != nil {
+		stats.Commands++
+	}
+	start := time.Now()
+	if policy.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(policy.Budget))
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = f(ctx)
+		if stats != nil {
+			stats.Attempts++
+			if err != nil {
+				stats.Errors = append(stats.Errors, err)
+			}
+		}
+		if err == nil || !policy.Retryable(err) || attempt >= policy.MaxAttempts {
+			return
+		}
+		wait := policy.backoff(attempt, rand.Float64())
+		if policy.Budget > 0 && time.Since(start)+wait > policy.Budget {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if stats != nil {
+			stats.Waited += wait
+		}
+	}
+}
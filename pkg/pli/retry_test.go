@@ -0,0 +1,127 @@
+package pli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/plisim"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1, 0.5))
+	assert.Equal(t, 200*time.Millisecond, p.backoff(2, 0.5))
+	assert.Equal(t, 800*time.Millisecond, p.backoff(4, 0.5))
+	assert.Equal(t, time.Second, p.backoff(5, 0.5))
+	assert.Equal(t, time.Second, p.backoff(100, 0.5))
+}
+
+func TestBackoffJitter(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.1}
+	assert.Equal(t, 90*time.Millisecond, p.backoff(1, 0))
+	assert.Equal(t, 110*time.Millisecond, p.backoff(1, 1))
+}
+
+func TestRetryable(t *testing.T) {
+	p := RetryPolicy{}
+	assert.True(t, p.Retryable(&ProtocolError{Code: 129}))
+	// Only a timeout is retried unless other codes are asked for
+	assert.False(t, p.Retryable(&ProtocolError{Code: 130}))
+	assert.False(t, p.Retryable(&ProtocolError{Code: 131}))
+	assert.False(t, p.Retryable(io.EOF))
+	assert.False(t, p.Retryable(ErrTimeout))
+	assert.False(t, p.Retryable(errors.New("something else")))
+
+	p = RetryPolicy{Codes: []byte{131}, RetryNoResponse: true}
+	assert.False(t, p.Retryable(&ProtocolError{Code: 130}))
+	assert.True(t, p.Retryable(&ProtocolError{Code: 131}))
+	assert.True(t, p.Retryable(io.EOF))
+	assert.True(t, p.Retryable(ErrTimeout))
+}
+
+func newRetrySim(t *testing.T, policy RetryPolicy) (*PLI, *plisim.Sim) {
+	sim := plisim.New()
+	pli, err := NewWithPort(sim)
+	assert.Nil(t, err)
+	pli.RetryPolicy = &policy
+	return pli, sim
+}
+
+func TestRetryPolicyWithSim(t *testing.T) {
+	pli, sim := newRetrySim(t, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Codes: []byte{plisim.CodeChecksum, plisim.CodeTimeout}})
+	sim.FailNext(plisim.CodeChecksum, plisim.CodeTimeout)
+
+	var stats RetryStats
+	soc, err := pli.StateOfChargeContext(WithRetryStats(context.Background(), &stats))
+	assert.Nil(t, err)
+	assert.Equal(t, 95, soc)
+	assert.Equal(t, 1, stats.Commands)
+	assert.Equal(t, 3, stats.Attempts)
+	assert.Equal(t, 2, stats.Retries())
+	assert.Equal(t, 2*time.Millisecond, stats.Waited)
+	assert.Len(t, stats.Errors, 2)
+	assert.True(t, errors.Is(stats.Errors[0], ErrChecksum))
+	assert.True(t, errors.Is(stats.Errors[1], ErrTimeout))
+}
+
+func TestRetryPolicyMaxAttempts(t *testing.T) {
+	pli, sim := newRetrySim(t, RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	sim.FailNext(plisim.CodeTimeout, plisim.CodeTimeout, plisim.CodeTimeout)
+
+	var stats RetryStats
+	_, err := pli.StateOfChargeContext(WithRetryStats(context.Background(), &stats))
+	assert.True(t, errors.Is(err, ErrTimeout))
+	assert.Equal(t, 2, stats.Attempts)
+	assert.Equal(t, 2, sim.Commands()-3)
+}
+
+func TestRetryPolicyCodes(t *testing.T) {
+	pli, sim := newRetrySim(t, RetryPolicy{MaxAttempts: 5, Codes: []byte{plisim.CodeTimeout}})
+	sim.FailNext(plisim.CodeChecksum)
+
+	var stats RetryStats
+	_, err := pli.StateOfChargeContext(WithRetryStats(context.Background(), &stats))
+	assert.True(t, errors.Is(err, ErrChecksum))
+	assert.Equal(t, 1, stats.Attempts)
+}
+
+func TestRetryPolicyBudget(t *testing.T) {
+	pli, sim := newRetrySim(t, RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour, Budget: time.Second})
+	sim.FailNext(plisim.CodeChecksum)
+
+	start := time.Now()
+	var stats RetryStats
+	_, err := pli.StateOfChargeContext(WithRetryStats(context.Background(), &stats))
+	assert.True(t, errors.Is(err, ErrChecksum))
+	assert.Equal(t, 1, stats.Attempts)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestRetryPolicyBudgetSlowAttempt(t *testing.T) {
+	// A PLI that never answers and a port without a timeout of its own
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go io.Copy(ioutil.Discard, server)
+	pli := PLI{Port: client, RetryPolicy: &RetryPolicy{MaxAttempts: 5, Budget: 50 * time.Millisecond}}
+
+	start := time.Now()
+	_, err := pli.ReadRAM(50)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestRetryNoResponse(t *testing.T) {
+	pli, sim := newRetrySim(t, RetryPolicy{MaxAttempts: 2, RetryNoResponse: true})
+	sim.IgnoreNext(1)
+
+	soc, err := pli.StateOfChargeContext(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 95, soc)
+}
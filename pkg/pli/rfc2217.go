@@ -0,0 +1,210 @@
+package pli
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// Talking to a PLI through a Telnet server that supports the COM port control
+// option (RFC 2217). This means that the serial port settings on the far end are set by
+// us rather than having to be configured on the server.
+
+const telnetSE = 240
+const telnetSB = 250
+const telnetWill = 251
+const telnetWont = 252
+const telnetDo = 253
+const telnetDont = 254
+const telnetIAC = 255
+
+const telnetOptionBinary = 0
+const telnetOptionSuppressGoAhead = 3
+const telnetOptionComPort = 44
+
+const comPortSetBaudRate = 1
+const comPortSetDataSize = 2
+const comPortSetParity = 3
+const comPortSetStopSize = 4
+const comPortSetControl = 5
+
+// States of the parser for the incoming telnet stream
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSubnegotiation
+	telnetStateSubnegotiationIAC
+)
+
+type telnetPort struct {
+	*netPort
+
+	writeMu sync.Mutex
+	state   int
+	command byte // The command (will, wont, do, dont) waiting for its option byte
+	buf     []byte
+}
+
+func dialRFC2217(address string, baudRate uint) (*telnetPort, error) {
+	err := checkBaudRate(baudRate)
+	if err != nil {
+		return nil, err
+	}
+	p, err := dialTCP(address)
+	if err != nil {
+		return nil, err
+	}
+	port := newTelnetPort(p)
+	err = port.negotiate(baudRate)
+	if err != nil {
+		port.Close()
+		return nil, err
+	}
+	return port, nil
+}
+
+func newTelnetPort(p *netPort) *telnetPort {
+	return &telnetPort{netPort: p, buf: make([]byte, 64)}
+}
+
+// negotiate asks for a binary connection and sets up the serial port on the far end in
+// the same way as we do for a local serial port. We don't wait around for the answers.
+// They get skipped over when reading.
+func (p *telnetPort) negotiate(baudRate uint) error {
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, uint32(baudRate))
+
+	b := []byte{
+		telnetIAC, telnetWill, telnetOptionComPort,
+		telnetIAC, telnetWill, telnetOptionBinary,
+		telnetIAC, telnetDo, telnetOptionBinary,
+		telnetIAC, telnetWill, telnetOptionSuppressGoAhead,
+		telnetIAC, telnetDo, telnetOptionSuppressGoAhead,
+	}
+	b = append(b, subnegotiation(comPortSetBaudRate, baud...)...)
+	b = append(b, subnegotiation(comPortSetDataSize, 8)...)
+	// 1 is no parity
+	b = append(b, subnegotiation(comPortSetParity, 1)...)
+	// 1 is one stop bit
+	b = append(b, subnegotiation(comPortSetStopSize, 1)...)
+	// 1 is no flow control
+	b = append(b, subnegotiation(comPortSetControl, 1)...)
+	return p.writeRaw(b)
+}
+
+func subnegotiation(command byte, value ...byte) []byte {
+	b := []byte{telnetIAC, telnetSB, telnetOptionComPort, command}
+	b = append(b, escapeIAC(value)...)
+	return append(b, telnetIAC, telnetSE)
+}
+
+// escapeIAC doubles up any bytes that would otherwise be read as the start of a telnet command
+func escapeIAC(b []byte) []byte {
+	escaped := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+		escaped = append(escaped, c)
+	}
+	return escaped
+}
+
+func (p *telnetPort) writeRaw(b []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	_, err := p.netPort.Write(b)
+	return err
+}
+
+func (p *telnetPort) Write(b []byte) (int, error) {
+	err := p.writeRaw(escapeIAC(b))
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read returns the data coming from the serial port with the telnet commands taken out
+func (p *telnetPort) Read(b []byte) (int, error) {
+	for {
+		size := len(b)
+		if size > len(p.buf) {
+			size = len(p.buf)
+		}
+		n, err := p.netPort.Read(p.buf[:size])
+		if err != nil {
+			return 0, err
+		}
+		data, err := p.parse(p.buf[:n], b[:0])
+		if err != nil {
+			return 0, err
+		}
+		if len(data) > 0 {
+			return len(data), nil
+		}
+	}
+}
+
+// parse pulls the data out of what has been received and appends it to data. Anything that
+// needs a reply gets one.
+func (p *telnetPort) parse(received []byte, data []byte) ([]byte, error) {
+	var reply []byte
+	for _, c := range received {
+		switch p.state {
+		case telnetStateData:
+			if c == telnetIAC {
+				p.state = telnetStateIAC
+			} else {
+				data = append(data, c)
+			}
+		case telnetStateIAC:
+			switch c {
+			case telnetIAC:
+				data = append(data, c)
+				p.state = telnetStateData
+			case telnetWill, telnetWont, telnetDo, telnetDont:
+				p.command = c
+				p.state = telnetStateOption
+			case telnetSB:
+				p.state = telnetStateSubnegotiation
+			default:
+				// Some other command that doesn't take an option. Ignore it.
+				p.state = telnetStateData
+			}
+		case telnetStateOption:
+			reply = append(reply, optionReply(p.command, c)...)
+			p.state = telnetStateData
+		case telnetStateSubnegotiation:
+			// The server telling us about the state of the serial port. We don't care.
+			if c == telnetIAC {
+				p.state = telnetStateSubnegotiationIAC
+			}
+		case telnetStateSubnegotiationIAC:
+			if c == telnetSE {
+				p.state = telnetStateData
+			} else {
+				p.state = telnetStateSubnegotiation
+			}
+		}
+	}
+	if len(reply) > 0 {
+		return data, p.writeRaw(reply)
+	}
+	return data, nil
+}
+
+// optionReply refuses any options from the server that we didn't ask for
+func optionReply(command byte, option byte) []byte {
+	switch option {
+	case telnetOptionBinary, telnetOptionSuppressGoAhead, telnetOptionComPort:
+		return nil
+	}
+	switch command {
+	case telnetDo:
+		return []byte{telnetIAC, telnetWont, option}
+	case telnetWill:
+		return []byte{telnetIAC, telnetDont, option}
+	}
+	return nil
+}
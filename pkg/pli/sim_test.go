@@ -0,0 +1,95 @@
+package pli
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/plisim"
+	"github.com/stretchr/testify/assert"
+)
+
+// End to end tests against a simulated PLI
+
+func TestNewWithSim(t *testing.T) {
+	pli, err := NewWithPort(plisim.New())
+	assert.Nil(t, err)
+	assert.Equal(t, PL80, pli.Model)
+	assert.Equal(t, 24, pli.Voltage)
+	assert.Equal(t, 0, pli.Prog)
+	assert.Equal(t, int(plisim.VersionPL80), pli.SoftwareVersion)
+}
+
+func TestReadersWithSim(t *testing.T) {
+	pli, err := NewWithPort(plisim.New())
+	assert.Nil(t, err)
+
+	v, err := pli.BatteryVoltage()
+	assert.Nil(t, err)
+	assert.Equal(t, float32(25.6), v)
+
+	// Scaled to 12V like the battery voltage
+	min, err := pli.BatteryMinVoltage()
+	assert.Nil(t, err)
+	assert.InDelta(t, 24.2, min, 0.0001)
+	max, err := pli.BatteryMaxVoltage()
+	assert.Nil(t, err)
+	assert.InDelta(t, 28.0, max, 0.0001)
+
+	bc, err := pli.BatteryCapacity()
+	assert.Nil(t, err)
+	assert.Equal(t, 880, bc)
+
+	h, m, s, err := pli.Time()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{12, 20, 30}, []int{h, m, s})
+
+	state, err := pli.RegulatorState()
+	assert.Nil(t, err)
+	assert.Equal(t, RegulatorStateFloat, state)
+}
+
+func TestWriteRAMVerifyWithSim(t *testing.T) {
+	sim := plisim.New()
+	pli, err := NewWithPort(sim)
+	assert.Nil(t, err)
+
+	assert.Nil(t, pli.WriteRAMVerify(94, 40))
+	assert.Equal(t, byte(40), sim.RAM(94))
+}
+
+func TestNotRecognisedWithSim(t *testing.T) {
+	sim := plisim.New()
+	pli, err := NewWithPort(sim)
+	assert.Nil(t, err)
+
+	commands := sim.Commands()
+	sim.FailNext(plisim.CodeNotRecognised)
+	_, err = pli.ReadRAM(50)
+	assert.True(t, errors.Is(err, ErrCommandNotRecognised))
+	assert.Equal(t, commands+1, sim.Commands())
+}
+
+func TestPLIMissingWithSim(t *testing.T) {
+	sim := plisim.New()
+	sim.IgnoreNext(1)
+	_, err := NewWithPort(sim)
+	assert.NotNil(t, err)
+}
+
+func TestPreciseBatteryVoltageWithSim(t *testing.T) {
+	sim := plisim.New()
+	sim.SetRAM(221, 128)
+	sim.SetRAM(220, 64) // A quarter of a step
+	pli, err := NewWithPort(sim, WithPreciseVoltage())
+	assert.Nil(t, err)
+
+	v, err := pli.PreciseBatteryVoltage()
+	assert.Nil(t, err)
+	assert.InDelta(t, 25.65, v, 0.0001)
+
+	r := pli.Snapshot()
+	assert.Nil(t, r.Err())
+	assert.InDelta(t, 25.65, r.PreciseBatteryVoltage, 0.0001)
+	// The ordinary battery voltage is still read the usual way
+	assert.InDelta(t, 25.6, r.BatteryVoltage, 0.0001)
+}
@@ -0,0 +1,130 @@
+package pli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reading is all the values that we regularly collect from the PL, read one after the other
+type Reading struct {
+	Start    time.Time     // When we started reading
+	End      time.Time     // When we finished reading
+	Duration time.Duration // How long it took to read everything
+
+	BatteryVoltage float32 // V
+	// PreciseBatteryVoltage is only read if the PLI was set up WithPreciseVoltage. Otherwise it's
+	// left as zero, which can't be told apart from a real reading, so check PreciseVoltage.
+	PreciseBatteryVoltage float32 // V
+	BatteryMinVoltage     float32 // V since midnight
+	BatteryMaxVoltage     float32 // V since midnight
+	BatteryCapacity       int     // Ah
+	StateOfCharge         int     // %
+	In                    int     // Ah
+	Out                   int     // Ah
+	Charge                float32 // A
+	Load                  float32 // A
+	RegulatorState        string
+	ExternalVoltage       float32 // V on the external voltage input
+
+	// Errors has the error for any value that couldn't be read, keyed by the name of the field
+	Errors map[string]error
+}
+
+// ReadingError is the error from a Reading where some of the values couldn't be read
+type ReadingError struct {
+	Errors map[string]error
+}
+
+func (e *ReadingError) Error() string {
+	var messages []string
+	for _, field := range e.fields() {
+		messages = append(messages, fmt.Sprintf("%v: %v", field, e.Errors[field]))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the error for one of the values. If there was a problem with the connection
+// to the PLI that's the one returned so that it isn't hidden behind some other error.
+func (e *ReadingError) Unwrap() error {
+	fields := e.fields()
+	for _, field := range fields {
+		if IsConnectionError(e.Errors[field]) {
+			return e.Errors[field]
+		}
+	}
+	return e.Errors[fields[0]]
+}
+
+func (e *ReadingError) fields() []string {
+	var fields []string
+	for field := range e.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Has is true if the named field was read without an error
+func (r *Reading) Has(field string) bool {
+	_, failed := r.Errors[field]
+	return !failed
+}
+
+// Err returns an error if any of the values couldn't be read
+func (r *Reading) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return &ReadingError{Errors: r.Errors}
+}
+
+// Snapshot reads everything in one go. If a value can't be read it carries on with the rest
+// and records the error against that value.
+func (pli *PLI) Snapshot() Reading {
+	return pli.SnapshotContext(context.Background())
+}
+
+// SnapshotContext is the same as Snapshot but takes a context
+func (pli *PLI) SnapshotContext(ctx context.Context) Reading {
+	r := Reading{Start: time.Now(), Errors: make(map[string]error)}
+	record := func(field string, err error) {
+		if err != nil {
+			r.Errors[field] = err
+		}
+	}
+
+	var err error
+	r.BatteryVoltage, err = pli.BatteryVoltageContext(ctx)
+	record("BatteryVoltage", err)
+	if pli.PreciseVoltage {
+		r.PreciseBatteryVoltage, err = pli.PreciseBatteryVoltageContext(ctx)
+		record("PreciseBatteryVoltage", err)
+	}
+	r.BatteryMinVoltage, err = pli.BatteryMinVoltageContext(ctx)
+	record("BatteryMinVoltage", err)
+	r.BatteryMaxVoltage, err = pli.BatteryMaxVoltageContext(ctx)
+	record("BatteryMaxVoltage", err)
+	r.BatteryCapacity, err = pli.BatteryCapacityContext(ctx)
+	record("BatteryCapacity", err)
+	r.StateOfCharge, err = pli.StateOfChargeContext(ctx)
+	record("StateOfCharge", err)
+	r.In, err = pli.InContext(ctx)
+	record("In", err)
+	r.Out, err = pli.OutContext(ctx)
+	record("Out", err)
+	r.Charge, err = pli.ChargeContext(ctx)
+	record("Charge", err)
+	r.Load, err = pli.LoadContext(ctx)
+	record("Load", err)
+	r.RegulatorState, err = pli.RegulatorStateContext(ctx)
+	record("RegulatorState", err)
+	r.ExternalVoltage, err = pli.ExternalVoltageContext(ctx)
+	record("ExternalVoltage", err)
+
+	r.End = time.Now()
+	r.Duration = r.End.Sub(r.Start)
+	return r
+}
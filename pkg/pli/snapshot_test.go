@@ -0,0 +1,31 @@
+package pli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	pli := PLI{Port: &ramPort{}, Voltage: 12, Model: PL80}
+	r := pli.Snapshot()
+	assert.Nil(t, r.Err())
+	assert.Equal(t, float32(5), r.BatteryVoltage)
+	assert.Equal(t, float32(12.4), r.BatteryMinVoltage)
+	assert.Equal(t, float32(12.5), r.BatteryMaxVoltage)
+	assert.Equal(t, 181, r.StateOfCharge)
+	assert.Equal(t, RegulatorStateEqualise, r.RegulatorState)
+	assert.Equal(t, float32(208), r.ExternalVoltage)
+	assert.True(t, r.End.After(r.Start))
+	assert.Equal(t, r.End.Sub(r.Start), r.Duration)
+	assert.True(t, r.Has("StateOfCharge"))
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	pli := PLI{Port: &fakePort{}}
+	r := pli.Snapshot()
+	assert.Len(t, r.Errors, 11)
+	assert.EqualError(t, r.Errors["StateOfCharge"], "EOF")
+	assert.False(t, r.Has("StateOfCharge"))
+	assert.Contains(t, r.Err().Error(), "BatteryCapacity: EOF; BatteryMaxVoltage: EOF; BatteryMinVoltage: EOF; BatteryVoltage: EOF; Charge: EOF; ExternalVoltage: EOF;")
+}
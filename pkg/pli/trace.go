@@ -0,0 +1,113 @@
+package pli
+
+import (
+	"fmt"
+	"io"
+	"log"
+)
+
+// Seeing exactly what goes over the wire when the PLI misbehaves
+
+// Tracer is told about every command sent to the PLI and the raw bytes that came back
+type Tracer interface {
+	// TraceCommand is called with the 4 bytes of a command just before it's sent
+	TraceCommand(command []byte)
+	// TraceResponse is called with whatever was read back after sending command and the
+	// error (if any) that came from it
+	TraceResponse(command []byte, response []byte, err error)
+}
+
+// LogTracer logs every command and response in hex along with what they mean. For example:
+//
+//	PLI > 14 32 00 eb (read RAM address 50)
+//	PLI < c8 80 (read RAM address 50: OK 128)
+type LogTracer struct {
+	Logger *log.Logger // Uses the standard logger if nil
+}
+
+func (t LogTracer) printf(format string, v ...interface{}) {
+	if t.Logger == nil {
+		log.Printf(format, v...)
+	} else {
+		t.Logger.Printf(format, v...)
+	}
+}
+
+func (t LogTracer) TraceCommand(command []byte) {
+	t.printf("PLI > %v (%v)", hexBytes(command), describeCommand(command))
+}
+
+func (t LogTracer) TraceResponse(command []byte, response []byte, err error) {
+	raw := hexBytes(response)
+	if raw == "" {
+		raw = "nothing"
+	}
+	t.printf("PLI < %v (%v: %v)", raw, describeCommand(command), describeResponse(response, err))
+}
+
+func describeCommand(command []byte) string {
+	switch command[0] {
+	case cmdLoopbackTest:
+		return commandName(command[0])
+	case cmdWriteRAM, cmdWriteEEPROM:
+		return fmt.Sprintf("%v address %v value %v", commandName(command[0]), command[1], command[2])
+	case cmdShortPush, cmdLongPush:
+		return fmt.Sprintf("%v button %v", commandName(command[0]), command[1])
+	default:
+		return fmt.Sprintf("%v address %v", commandName(command[0]), command[1])
+	}
+}
+
+func describeResponse(response []byte, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	switch len(response) {
+	case 1:
+		if response[0] == 200 {
+			return "OK"
+		}
+		return fmt.Sprintf("code %v", response[0])
+	case 2:
+		return fmt.Sprintf("OK %v", response[1])
+	default:
+		return "OK"
+	}
+}
+
+// tracingReader keeps a copy of everything read through it
+type tracingReader struct {
+	r    io.Reader
+	data []byte
+}
+
+func (t *tracingReader) Read(b []byte) (int, error) {
+	n, err := t.r.Read(b)
+	t.data = append(t.data, b[:n]...)
+	return n, err
+}
+
+// transact sends a command to the PLI and reads the response with read, telling the tracer
+// (if there is one) about both
+func (pli *PLI) transact(c byte, address byte, value byte, read func(port io.Reader) error) error {
+	tracer := pli.Tracer
+	if tracer == nil {
+		err := command(pli.Port, c, address, value)
+		if err != nil {
+			return err
+		}
+		return read(pli.Port)
+	}
+
+	cmd := []byte{c, address, value, 255 - c}
+	tracer.TraceCommand(cmd)
+	err := command(pli.Port, c, address, value)
+	if err != nil {
+		tracer.TraceResponse(cmd, nil, err)
+		return err
+	}
+	r := &tracingReader{r: pli.Port}
+	err = read(r)
+	tracer.TraceResponse(cmd, r.data, err)
+	return err
+}
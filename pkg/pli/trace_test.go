@@ -0,0 +1,68 @@
+package pli
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/plisim"
+	"github.com/stretchr/testify/assert"
+)
+
+type traced struct {
+	command  []byte
+	response []byte
+	err      error
+}
+
+type fakeTracer struct {
+	commands  [][]byte
+	responses []traced
+}
+
+func (t *fakeTracer) TraceCommand(command []byte) {
+	t.commands = append(t.commands, command)
+}
+
+func (t *fakeTracer) TraceResponse(command []byte, response []byte, err error) {
+	t.responses = append(t.responses, traced{command, response, err})
+}
+
+func TestTracer(t *testing.T) {
+	sim := plisim.New()
+	tracer := &fakeTracer{}
+	pli, err := NewWithPort(sim, WithTracer(tracer))
+	assert.Nil(t, err)
+	// The handshake is traced too
+	assert.Equal(t, []byte{187, 0, 0, 68}, tracer.commands[0])
+	assert.Equal(t, []byte{128}, tracer.responses[0].response)
+	assert.Nil(t, tracer.responses[0].err)
+
+	tracer.commands = nil
+	tracer.responses = nil
+	_, err = pli.StateOfCharge()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{{20, 181, 0, 235}}, tracer.commands)
+	assert.Equal(t, []traced{{[]byte{20, 181, 0, 235}, []byte{200, 95}, nil}}, tracer.responses)
+}
+
+func TestLogTracer(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := LogTracer{Logger: log.New(&buf, "", 0)}
+	tracer.TraceCommand([]byte{20, 50, 0, 235})
+	tracer.TraceResponse([]byte{20, 50, 0, 235}, []byte{200, 128}, nil)
+	tracer.TraceCommand([]byte{152, 50, 7, 103})
+	tracer.TraceResponse([]byte{152, 50, 7, 103}, []byte{200}, nil)
+	tracer.TraceResponse([]byte{20, 50, 0, 235}, []byte{130}, &ProtocolError{Code: 130})
+	tracer.TraceResponse([]byte{20, 50, 0, 235}, nil, errors.New("EOF"))
+	assert.Equal(t, []string{
+		"PLI > 14 32 00 eb (read RAM address 50)",
+		"PLI < c8 80 (read RAM address 50: OK 128)",
+		"PLI > 98 32 07 67 (write RAM address 50 value 7)",
+		"PLI < c8 (write RAM address 50 value 7: OK)",
+		"PLI < 82 (read RAM address 50: PLI Error: Checksum error in PLI receive data)",
+		"PLI < nothing (read RAM address 50: EOF)",
+	}, strings.Split(strings.TrimSpace(buf.String()), "\n"))
+}
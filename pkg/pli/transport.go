@@ -0,0 +1,170 @@
+package pli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// Different ways of getting bytes to and from the PLI. The PLI itself only knows about
+// RS-232 but it doesn't have to be plugged directly into the computer we're running on.
+
+const defaultBaudRate = 9600
+
+// How long we wait for a new byte to arrive before giving up
+const interCharacterTimeout = time.Second
+
+// How long we wait to connect to a PLI over the network
+const dialTimeout = 10 * time.Second
+
+// OpenPort opens a connection to a PLI described by a URL. These are supported:
+//
+//	serial:///dev/ttyUSB0?baud=9600 - serial port on this machine (or just /dev/ttyUSB0)
+//	serial:///dev/ttyUSB0?baud=auto - serial port trying each baud rate the PLI supports
+//	tcp://shed:4001 - raw TCP connection to something like ser2net
+//	rfc2217://shed:4001?baud=9600 - Telnet with COM port control (RFC 2217)
+//	replay:///path/to/recording - plays back a recording made with Recorder
+//
+// It also returns the baud rate being used or zero if that doesn't apply
+func OpenPort(rawurl string) (io.ReadWriteCloser, uint, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, 0, err
+	}
+	baudRate := uint64(defaultBaudRate)
+	b := u.Query().Get("baud")
+	if b == "auto" {
+		baudRate = AutoBaudRate
+	} else if b != "" {
+		baudRate, err = strconv.ParseUint(b, 10, 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Invalid baud rate %q", b)
+		}
+	}
+	switch u.Scheme {
+	case "", "serial":
+		return openSerialDetect(u.Path, uint(baudRate))
+	case "tcp":
+		port, err := dialTCP(u.Host)
+		return port, 0, err
+	case "rfc2217":
+		if baudRate == AutoBaudRate {
+			return nil, 0, errors.New("Automatic baud rate is only supported for serial ports")
+		}
+		port, err := dialRFC2217(u.Host, uint(baudRate))
+		return port, uint(baudRate), err
+	case "replay":
+		port, err := openReplay(u.Path)
+		return port, 0, err
+	default:
+		return nil, 0, fmt.Errorf("Unsupported PLI URL scheme %q", u.Scheme)
+	}
+}
+
+// Open connects to the PLI described by a URL (see OpenPort) and sets up communication with it
+func Open(rawurl string, opts ...Option) (*PLI, error) {
+	port, baudRate, err := OpenPort(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	pli, err := NewWithPort(port, opts...)
+	if pli != nil {
+		pli.BaudRate = baudRate
+	}
+	return pli, err
+}
+
+func openSerial(portName string, baudRate uint) (io.ReadWriteCloser, error) {
+	err := checkBaudRate(baudRate)
+	if err != nil {
+		return nil, err
+	}
+	// Set up options.
+	// 8 bit, No parity, 1 stop bit is what the PLI expects
+	// 9600 baud is the fastest speed the PLI can work at. That baud rate needs to be setup
+	// with DIP switches on the PLI circuitboard itself. This is like a little glimpse into the past.
+	options := serial.OpenOptions{
+		PortName:              portName,
+		BaudRate:              baudRate,
+		DataBits:              8,
+		StopBits:              1,
+		ParityMode:            serial.PARITY_NONE,
+		InterCharacterTimeout: uint(interCharacterTimeout / time.Millisecond),
+	}
+	return serial.Open(options)
+}
+
+func openReplay(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadRecording(f)
+}
+
+func dialTCP(address string) (*netPort, error) {
+	conn, err := net.DialTimeout("tcp", address, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return newNetPort(conn), nil
+}
+
+var ErrConnectionClosed = errors.New("Connection to the PLI was closed by the other end")
+
+// netPort makes a network connection behave like a serial port. A read that doesn't get
+// anything within the inter character timeout returns io.EOF. So, if the other end closes
+// the connection that's ErrConnectionClosed instead. A deadline can also be set (which is
+// how reads are cancelled) and that applies on top of the timeout.
+type netPort struct {
+	net.Conn
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func newNetPort(conn net.Conn) *netPort {
+	return &netPort{Conn: conn}
+}
+
+// Must be called with mu held
+func (p *netPort) setConnDeadline() error {
+	d := time.Now().Add(interCharacterTimeout)
+	if !p.deadline.IsZero() && p.deadline.Before(d) {
+		d = p.deadline
+	}
+	return p.Conn.SetReadDeadline(d)
+}
+
+func (p *netPort) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deadline = t
+	return p.setConnDeadline()
+}
+
+func (p *netPort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	err := p.setConnDeadline()
+	p.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n, err := p.Conn.Read(b)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && n == 0 {
+		return 0, io.EOF
+	}
+	if err == io.EOF {
+		return n, ErrConnectionClosed
+	}
+	return n, err
+}
@@ -0,0 +1,78 @@
+package pli
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenPortUnsupportedScheme(t *testing.T) {
+	_, _, err := OpenPort("http://shed:4001")
+	assert.EqualError(t, err, `Unsupported PLI URL scheme "http"`)
+}
+
+func TestOpenPortInvalidBaudRate(t *testing.T) {
+	_, _, err := OpenPort("serial:///dev/ttyUSB0?baud=fast")
+	assert.EqualError(t, err, `Invalid baud rate "fast"`)
+}
+
+func TestTelnetPort(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	port := newTelnetPort(newNetPort(client))
+
+	// A read RAM command with an address of 255 needs escaping
+	go port.Write([]byte{20, 255, 0, 235})
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(server, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{20, 255, 255, 0, 235}, buf)
+
+	// The server asks us to echo (which we refuse), tells us about the
+	// line state and then sends an escaped 255 as data
+	replies := make(chan []byte, 1)
+	go func() {
+		server.Write([]byte{telnetIAC, telnetDo, 1})
+		reply := make([]byte, 3)
+		io.ReadFull(server, reply)
+		replies <- reply
+		server.Write([]byte{
+			telnetIAC, telnetSB, telnetOptionComPort, 106, 0, telnetIAC, telnetSE,
+			200, telnetIAC, telnetIAC,
+		})
+	}()
+	data := make([]byte, 2)
+	n, err := io.ReadFull(port, data)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []byte{200, 255}, data)
+	assert.Equal(t, []byte{telnetIAC, telnetWont, 1}, <-replies)
+}
+
+func TestNetPortClosedByOtherEnd(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go func() {
+		server, err := l.Accept()
+		if err == nil {
+			server.Close()
+		}
+	}()
+	port, err := dialTCP(l.Addr().String())
+	assert.Nil(t, err)
+	defer port.Close()
+	_, err = port.Read(make([]byte, 1))
+	assert.Equal(t, ErrConnectionClosed, err)
+}
+
+func TestOpenPortUnsupportedBaudRate(t *testing.T) {
+	_, _, err := OpenPort("serial:///dev/ttyUSB0?baud=19200")
+	assert.True(t, errors.Is(err, ErrUnsupportedBaudRate))
+	_, _, err = OpenPort("rfc2217://shed:4001?baud=auto")
+	assert.NotNil(t, err)
+}
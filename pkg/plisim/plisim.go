@@ -0,0 +1,216 @@
+// Package plisim pretends to be a PLI plugged into a PL regulator. It speaks the same
+// protocol over an io.ReadWriteCloser so that the pli package (and anything built on it)
+// can be tried out without any hardware.
+package plisim
+
+import (
+	"io"
+	"sync"
+)
+
+// Commands understood by the PLI
+const commandReadRAM = 20
+const commandReadEEPROM = 72
+const commandShortPush = 87
+const commandLongPush = 92
+const commandWriteRAM = 152
+const commandLoopbackTest = 187
+const commandWriteEEPROM = 202
+
+// Codes sent back by the PLI
+const CodeOK byte = 200
+const CodeNoComms byte = 5
+const CodeLoopback byte = 128
+const CodeTimeout byte = 129
+const CodeChecksum byte = 130
+const CodeNotRecognised byte = 131
+const CodeNoReply byte = 133
+const CodeReplyError byte = 134
+
+// Software versions (stored at RAM address 0) for each model of PL
+const VersionPL20 byte = 100
+const VersionPL40 byte = 150
+const VersionPL60 byte = 200
+const VersionPL80 byte = 230
+
+// Push is a button push that the simulated PL received
+type Push struct {
+	Button byte
+	Long   bool
+}
+
+// Sim is a simulated PLI and PL. Writes are commands to the PLI and reads are its responses.
+// Like a serial port with a timeout, a read when there's no response waiting returns io.EOF.
+type Sim struct {
+	mu sync.Mutex
+
+	ram    [256]byte
+	eeprom [256]byte
+	pushes []Push
+
+	// Error codes to send back instead of the next responses
+	failures []byte
+	// Number of commands still to be ignored completely
+	ignore int
+
+	command  []byte // Bytes of a command that hasn't been completely received yet
+	response []byte // Bytes waiting to be read
+	commands int    // Number of commands received
+	closed   bool
+}
+
+// New creates a simulated 24V PL80 with a 880Ah battery at 25.6V in the middle of the day
+func New() *Sim {
+	s := &Sim{}
+	s.ram[0] = VersionPL80
+	s.ram[46] = 15  // 30 seconds
+	s.ram[47] = 2   // 2 minutes
+	s.ram[48] = 123 // 12.3 hours
+	s.ram[50] = 128 // 12.8V scaled to 12V
+	s.ram[93] = 0x01
+	s.ram[94] = 44
+	s.ram[101] = 3   // float
+	s.ram[124] = 121 // 12.1V lowest today scaled to 12V
+	s.ram[125] = 140 // 14.0V highest today scaled to 12V
+	s.ram[181] = 95
+	return s
+}
+
+// NewWithRAM creates a simulated PL whose RAM starts off as a copy of ram
+func NewWithRAM(ram [256]byte) *Sim {
+	return &Sim{ram: ram}
+}
+
+// RAM returns the value at an address in the simulated PL's RAM
+func (s *Sim) RAM(address byte) byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ram[address]
+}
+
+// SetRAM changes a value in the simulated PL's RAM
+func (s *Sim) SetRAM(address byte, value byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ram[address] = value
+}
+
+// EEPROM returns the value at an address in the simulated PL's EEPROM
+func (s *Sim) EEPROM(address byte) byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.eeprom[address]
+}
+
+// SetEEPROM changes a value in the simulated PL's EEPROM
+func (s *Sim) SetEEPROM(address byte, value byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.eeprom[address] = value
+}
+
+// SetModel changes the software version which is how the model of PL is worked out
+func (s *Sim) SetModel(version byte) {
+	s.SetRAM(0, version)
+}
+
+// Pushes returns the button pushes received so far
+func (s *Sim) Pushes() []Push {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Push(nil), s.pushes...)
+}
+
+// Commands returns the number of commands received so far
+func (s *Sim) Commands() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands
+}
+
+// FailNext makes the next commands get the given error codes (one code per command)
+// instead of their normal response. Commands being ignored don't use up a code.
+func (s *Sim) FailNext(codes ...byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, codes...)
+}
+
+// IgnoreNext makes the next n commands get no response at all, as if the PLI wasn't there
+func (s *Sim) IgnoreNext(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ignore += n
+}
+
+func (s *Sim) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	s.command = append(s.command, b...)
+	for len(s.command) >= 4 {
+		s.handle(s.command[0], s.command[1], s.command[2], s.command[3])
+		s.command = s.command[4:]
+	}
+	return len(b), nil
+}
+
+func (s *Sim) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if len(s.response) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, s.response)
+	s.response = s.response[n:]
+	return n, nil
+}
+
+func (s *Sim) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Must be called with mu held
+func (s *Sim) handle(command byte, address byte, value byte, checksum byte) {
+	s.commands++
+	if s.ignore > 0 {
+		s.ignore--
+		return
+	}
+	if len(s.failures) > 0 {
+		s.response = append(s.response, s.failures[0])
+		s.failures = s.failures[1:]
+		return
+	}
+	if checksum != 255-command {
+		s.response = append(s.response, CodeChecksum)
+		return
+	}
+	switch command {
+	case commandReadRAM:
+		s.response = append(s.response, CodeOK, s.ram[address])
+	case commandReadEEPROM:
+		s.response = append(s.response, CodeOK, s.eeprom[address])
+	case commandWriteRAM:
+		s.ram[address] = value
+		s.response = append(s.response, CodeOK)
+	case commandWriteEEPROM:
+		s.eeprom[address] = value
+		s.response = append(s.response, CodeOK)
+	case commandShortPush, commandLongPush:
+		s.pushes = append(s.pushes, Push{Button: address, Long: command == commandLongPush})
+		s.response = append(s.response, CodeOK)
+	case commandLoopbackTest:
+		s.response = append(s.response, CodeLoopback)
+	default:
+		s.response = append(s.response, CodeNotRecognised)
+	}
+}
@@ -0,0 +1,47 @@
+package plisim
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func exchange(s *Sim, command []byte) []byte {
+	s.Write(command)
+	buf := make([]byte, 4)
+	n, _ := s.Read(buf)
+	return buf[:n]
+}
+
+func TestReadRAM(t *testing.T) {
+	s := New()
+	assert.Equal(t, []byte{CodeOK, 128}, exchange(s, []byte{20, 50, 0, 235}))
+}
+
+func TestWriteEEPROM(t *testing.T) {
+	s := New()
+	assert.Equal(t, []byte{CodeOK}, exchange(s, []byte{202, 94, 40, 53}))
+	assert.Equal(t, byte(40), s.EEPROM(94))
+}
+
+func TestChecksum(t *testing.T) {
+	s := New()
+	assert.Equal(t, []byte{CodeChecksum}, exchange(s, []byte{20, 50, 0, 0}))
+}
+
+func TestFailures(t *testing.T) {
+	s := New()
+	s.IgnoreNext(1)
+	s.FailNext(CodeTimeout)
+	assert.Equal(t, []byte{}, exchange(s, []byte{187, 0, 0, 68}))
+	assert.Equal(t, []byte{CodeTimeout}, exchange(s, []byte{187, 0, 0, 68}))
+	assert.Equal(t, []byte{CodeLoopback}, exchange(s, []byte{187, 0, 0, 68}))
+	assert.Equal(t, 3, s.Commands())
+}
+
+func TestReadNothing(t *testing.T) {
+	s := New()
+	_, err := s.Read(make([]byte, 2))
+	assert.Equal(t, io.EOF, err)
+}
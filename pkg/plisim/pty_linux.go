@@ -0,0 +1,82 @@
+package plisim
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// PTY serves the simulator on a Linux pseudo-terminal so that anything expecting a real serial
+// device can talk to it. The terminal is put into raw mode by whoever opens it (pli.New does).
+type PTY struct {
+	// Path of the device to open (something like /dev/pts/3)
+	Path string
+
+	master *os.File
+	done   chan struct{}
+}
+
+// ServePTY creates a pseudo-terminal and answers commands written to it until Close is called
+func (s *Sim) ServePTY() (*PTY, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	var unlock int32
+	err = ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock))
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	var n uint32
+	err = ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n))
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	p := &PTY{Path: fmt.Sprintf("/dev/pts/%d", n), master: master, done: make(chan struct{})}
+	go p.serve(s)
+	return p, nil
+}
+
+func ioctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(arg))
+	if errno != 0 {
+		return os.NewSyscallError("SYS_IOCTL", errno)
+	}
+	return nil
+}
+
+func (p *PTY) serve(s *Sim) {
+	defer close(p.done)
+	buf := make([]byte, 64)
+	for {
+		n, err := p.master.Read(buf)
+		if err != nil {
+			return
+		}
+		s.Write(buf[:n])
+		for {
+			n, err := s.Read(buf)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return
+			}
+			_, err = p.master.Write(buf[:n])
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close stops serving and removes the pseudo-terminal
+func (p *PTY) Close() error {
+	err := p.master.Close()
+	<-p.done
+	return err
+}
@@ -0,0 +1,38 @@
+package plisim
+
+import (
+	"testing"
+
+	"github.com/mlandauer/solar-battery-monitoring/pkg/pli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServePTY(t *testing.T) {
+	pty, err := New().ServePTY()
+	if err != nil {
+		t.Skipf("Can't create a pseudo-terminal: %v", err)
+	}
+	defer pty.Close()
+
+	p, err := pli.New(pty.Path, 9600)
+	assert.Nil(t, err)
+	defer p.Close()
+	assert.Equal(t, pli.PL80, p.Model)
+
+	v, err := p.BatteryVoltage()
+	assert.Nil(t, err)
+	assert.Equal(t, float32(25.6), v)
+}
+
+func TestServePTYAutoBaudRate(t *testing.T) {
+	pty, err := New().ServePTY()
+	if err != nil {
+		t.Skipf("Can't create a pseudo-terminal: %v", err)
+	}
+	defer pty.Close()
+
+	p, err := pli.New(pty.Path, pli.AutoBaudRate)
+	assert.Nil(t, err)
+	defer p.Close()
+	assert.Equal(t, uint(9600), p.BaudRate)
+}